@@ -0,0 +1,25 @@
+// Package blob stores the files users upload, like the images proving a task submission
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound means no blob is stored under the key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey means the key can't name a blob, keys are slash separated relative paths
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps blobs under keys chosen by the caller. Implementations must be safe for
+// concurrent use, a blob is either stored completely or not at all.
+type Store interface {
+	// Put stores the content read from r under the key, replacing any blob stored there
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the content of the blob, the caller closes it
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,67 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(ctx, "submissions/1/proof.png", strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "submissions/1/proof.png", strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := store.Open(ctx, "submissions/1/proof.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(content) != "second" {
+		t.Errorf("content = %q, %v, want second", content, err)
+	}
+
+	// nothing but the blob is left in its directory
+	entries, err := os.ReadDir(filepath.Join(dir, "submissions", "1"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("files of the blob directory = %v, %v", entries, err)
+	}
+
+	if err := store.Delete(ctx, "submissions/1/proof.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, "submissions/1/proof.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open of a deleted blob returned %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "submissions/1/proof.png"); err != nil {
+		t.Errorf("Delete of a missing blob returned %v", err)
+	}
+
+	for _, key := range []string{"", "../outside", "/etc/passwd", "a/../../outside"} {
+		if err := store.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.Put(cancelled, "cancelled", strings.NewReader("x")); err == nil {
+		t.Error("Put with a cancelled context succeeded")
+	}
+	if _, err := store.Open(ctx, "cancelled"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open of a cancelled upload returned %v, want ErrNotFound", err)
+	}
+}
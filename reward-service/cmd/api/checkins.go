@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reward-service/data"
+)
+
+// userStatus is a user with their streak of daily check-ins
+type userStatus struct {
+	*data.User
+	data.Streak
+}
+
+// checkinRules returns the configured rules of the daily check-in
+func (app *Config) checkinRules() data.CheckinRules {
+	return data.CheckinRules{
+		Rewards:     app.Settings.CheckinRewards,
+		Grace:       app.Settings.CheckinGrace,
+		FreezeEvery: app.Settings.FreezeEvery,
+		MaxFreezes:  app.Settings.MaxFreezes,
+	}
+}
+
+// checkIn awards the points of the daily check-in, once per calendar day of the user. The
+// points grow with the streak of consecutive days. Users check in themselves, admins
+// anyone.
+func (app *Config) checkIn(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	change := data.PointChange{
+		Source:  data.SourceCheckin,
+		Reason:  "daily check-in",
+		ActorID: callerID(r.Context()),
+	}
+	checkin, err := app.Repo.CheckIn(r.Context(), id, app.checkinRules(), change)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't check in: %w", err))
+		return
+	}
+	pointsAwarded.WithLabelValues(data.SourceCheckin).Add(float64(checkin.Points))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("checked in user with id %d on day %d of the streak, added points %d", id, checkin.Streak, checkin.Points),
+		Data:    checkin,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// grantStreakFreezes gives the user streak freezes, admins only
+func (app *Config) grantStreakFreezes(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Count int `json:"count" validate:"required,min=1,max=100"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	streak, err := app.Repo.GrantStreakFreezes(r.Context(), id, requestPayload.Count)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't grant streak freezes: %w", err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("granted %d streak freezes to user with id %d", requestPayload.Count, id),
+		Data:    streak,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckIn(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "daily@example.com", "pw")
+	token := ta.login(t, "daily@example.com", "pw")
+	ta.Settings.CheckinRewards = []int{15, 25}
+	base := "/users/" + strconv.Itoa(id)
+
+	rec := ta.do(t, http.MethodPost, base+"/checkin", nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("check-in = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data data.Checkin `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Streak != 1 || resp.Data.Points != 15 {
+		t.Errorf("check-in = %+v", resp.Data)
+	}
+
+	rec = ta.doWithHeaders(t, http.MethodPost, base+"/checkin", nil, token, http.Header{"Accept": {problemContentType}})
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "already_checked_in") ||
+		!strings.Contains(rec.Body.String(), "available_at") || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("second check-in = %d %q: %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+	if score := ta.score(t, id); score != 15 {
+		t.Errorf("score = %d, want 15", score)
+	}
+
+	var status struct {
+		Data struct {
+			Email   string `json:"email"`
+			Current int    `json:"current_streak"`
+			Longest int    `json:"longest_streak"`
+		} `json:"data"`
+	}
+	rec = ta.do(t, http.MethodGet, base+"/status", nil, token)
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Data.Email != "daily@example.com" || status.Data.Current != 1 || status.Data.Longest != 1 {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	other := ta.register(t, "lazy@example.com", "pw")
+	if rec := ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(other)+"/checkin", nil, token); rec.Code != http.StatusForbidden {
+		t.Errorf("check-in of another user = %d", rec.Code)
+	}
+
+	// streak freezes are granted by admins
+	freezes := map[string]int{"count": 2}
+	if rec := ta.do(t, http.MethodPost, base+"/streak-freezes", freezes, token); rec.Code != http.StatusForbidden {
+		t.Errorf("grant by a user = %d", rec.Code)
+	}
+	if _, err := ta.repo.Insert(context.Background(), data.User{Email: "freezer@example.com", Password: "pw", Role: data.RoleAdmin, Active: 1}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := ta.login(t, "freezer@example.com", "pw")
+	rec = ta.do(t, http.MethodPost, base+"/streak-freezes", freezes, adminToken)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"streak_freezes":2`) {
+		t.Errorf("grant = %d: %s", rec.Code, rec.Body)
+	}
+}
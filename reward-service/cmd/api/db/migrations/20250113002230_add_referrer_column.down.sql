@@ -1 +0,0 @@
-ALTER TABLE users DROP COLUMN referrer;
\ No newline at end of file
@@ -1 +0,0 @@
-ALTER TABLE users ADD COLUMN referrer VARCHAR(255);
\ No newline at end of file
@@ -5,12 +5,10 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"reward-service/data"
-	"strconv"
 	"time"
 )
 
@@ -32,6 +30,8 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Referrer  string    `json:"referrer,omitempty"`
+	Role      string    `json:"role"`
+	Timezone  string    `json:"timezone"`
 }
 
 type contextKey string
@@ -41,18 +41,17 @@ const userIDKey contextKey = "userID"
 // Registrate insert new user to the database
 func (app *Config) Registrate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email     string `json:"email"`
-		FirstName string `json:"first_name,omitempty"`
-		LastName  string `json:"last_name,omitempty"`
-		Password  string `json:"password"`
-		Active    int    `json:"active"`
-		Score     int    `json:"score"`
-		Referrer  string `json:"referrer,omitempty"`
+		Email     string `json:"email" validate:"required,email,max=255"`
+		FirstName string `json:"first_name,omitempty" validate:"max=100"`
+		LastName  string `json:"last_name,omitempty" validate:"max=100"`
+		Password  string `json:"password" validate:"required,min=8,maxbytes=72"`
+		Referrer  string `json:"referrer,omitempty" validate:"max=255"`
+		Timezone  string `json:"timezone,omitempty" validate:"omitempty,max=64,timezone"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
 	user := User{
@@ -60,28 +59,31 @@ func (app *Config) Registrate(w http.ResponseWriter, r *http.Request) {
 		FirstName: requestPayload.FirstName,
 		LastName:  requestPayload.LastName,
 		Password:  requestPayload.Password,
-		Active:    requestPayload.Active,
-		Score:     requestPayload.Score,
+		Active:    1,
 		Referrer:  requestPayload.Referrer,
+		Timezone:  requestPayload.Timezone,
 	}
-	id, err := app.Repo.Insert(data.User(user))
+	id, err := app.Repo.Insert(r.Context(), data.User(user))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
+	registrations.Inc()
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("Succesfully created new user, id: %s", id),
+		Message: fmt.Sprintf("Succesfully created new user, id: %d", id),
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	headers := http.Header{}
+	headers.Set("Location", fmt.Sprintf("/users/%d/status", id))
+	app.writeJSON(w, http.StatusCreated, payload, headers)
 }
 
 // GetLeaderboard retrieves all users from the database, sort them by points
 func (app *Config) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
-	users, err := app.Repo.GetAll()
+	users, err := app.Repo.GetAll(r.Context())
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't fetch All users"), http.StatusBadRequest)
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch All users: %w", err))
 		return
 	}
 
@@ -91,40 +93,48 @@ func (app *Config) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
 		Data:    users,
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 
 }
 
 // Authenticate authenticates user by provided email and password, provides tokens to access
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,max=255"`
+		Password string `json:"password" validate:"required,maxbytes=72"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	user, err := app.Repo.GetByEmail(requestPayload.Email)
-
+	user, err := app.Repo.GetByEmail(r.Context(), requestPayload.Email)
+	if errors.Is(err, data.ErrNotFound) {
+		failedLogins.Inc()
+		app.errorJSON(w, r, errInvalidCredentials)
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials 75"), http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
 
 	valid, err := app.Repo.PasswordMatches(requestPayload.Password, *user)
 	if err != nil || !valid {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		failedLogins.Inc()
+		app.errorJSON(w, r, errInvalidCredentials)
+		return
+	}
+	if user.Active == 0 {
+		app.errorJSON(w, r, errAccountInactive)
 		return
 	}
 
-	secretKey := "some_secret_key"
-	userData, err := generateTokens(user.ID, secretKey)
+	userData, err := generateTokens(user.ID, app.Settings.JWTSecret, app.Settings.TokenTTL)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -135,11 +145,11 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(15 * time.Minute),
+		Expires:  time.Now().Add(app.Settings.TokenTTL),
 	})
 	err = validateRefreshToken(userData.HashedRefreshToken, userData.RefreshToken)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 	payload := jsonResponse{
@@ -148,12 +158,12 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		Data:    user,
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
 // generateToken generates refresh and access tokens for the user
-func generateTokens(userID int, secretKey string) (*UserData, error) {
-	accessToken, err := generateAccessToken(userID, secretKey)
+func generateTokens(userID int, secretKey string, ttl time.Duration) (*UserData, error) {
+	accessToken, err := generateAccessToken(userID, secretKey, ttl)
 	if err != nil {
 		return nil, err
 	}
@@ -194,8 +204,8 @@ func generateRefreshToken(secretKey string) (string, string, error) {
 }
 
 // generateAccessToken generates access tokens based on who was authenticated
-func generateAccessToken(userID int, secretKey string) (string, error) {
-	expirationTime := time.Now().Add(time.Minute * 15)
+func generateAccessToken(userID int, secretKey string, ttl time.Duration) (string, error) {
+	expirationTime := time.Now().Add(ttl)
 
 	claims := &jwt.MapClaims{
 		"sub": userID,
@@ -218,7 +228,7 @@ func (app *Config) authTokenMiddleware(secretKey string) func(http.Handler) http
 
 			cookie, err := r.Cookie("access_token")
 			if err != nil {
-				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+				app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 				return
 			}
 			tokenString := cookie.Value
@@ -230,16 +240,32 @@ func (app *Config) authTokenMiddleware(secretKey string) func(http.Handler) http
 			})
 
 			if err != nil || !token.Valid {
-				app.errorJSON(w, errors.New("token is not valid"), http.StatusUnauthorized)
+				app.errorJSON(w, r, errors.New("token is not valid"), http.StatusUnauthorized)
 				return
 			}
 
 			userID, ok := (*claims)["sub"].(float64)
 			if !ok {
-				app.errorJSON(w, errors.New("invalid token claims"), http.StatusUnauthorized)
+				app.errorJSON(w, r, errors.New("invalid token claims"), http.StatusUnauthorized)
+				return
+			}
+
+			// a deactivated or deleted user loses access before their token expires
+			user, err := app.Repo.GetOne(r.Context(), int(userID))
+			if errors.Is(err, data.ErrNotFound) {
+				app.errorJSON(w, r, errors.New("token is not valid"), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				app.errorJSON(w, r, err)
+				return
+			}
+			if user.Active == 0 {
+				app.errorJSON(w, r, errAccountInactive)
 				return
 			}
 
+			withLoggerAttrs(r.Context(), "user_id", int(userID))
 			ctx := context.WithValue(r.Context(), userIDKey, int(userID))
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
@@ -247,37 +273,49 @@ func (app *Config) authTokenMiddleware(secretKey string) func(http.Handler) http
 	}
 }
 
-// addPoint adds some points to some user
-func (app *Config) addPoint(point, id int) error {
-	err := app.Repo.AddPoints(id, point)
+// addPoint adds some points to some user, task names the task type the points are awarded for
+func (app *Config) addPoint(ctx context.Context, point, id int, task string) error {
+	change := data.PointChange{
+		Source:    data.SourceTask,
+		Reason:    "task completed",
+		Reference: task,
+		ActorID:   callerID(ctx),
+	}
+	err := app.Repo.AddPoints(ctx, id, point, change)
 	if err != nil {
-		fmt.Println("Error in reward service, couldn't add point")
+		loggerFrom(ctx).Error("Couldn't add points", "user", id, "points", point, "task", task, "error", err)
 		return err
 	}
+	pointsAwarded.WithLabelValues(task).Add(float64(point))
 
 	return nil
 }
 
-// completeTask completes various task and adding some point to the user
+// completeTask credits the user with points for a custom task which isn't in the catalog,
+// admins only. Every call adds the points, the tasks of the catalog are completed through
+// completeTaskCode instead.
 func (app *Config) completeTask(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Points int `json:"points"`
+		Points int `json:"points" validate:"required,min=1,max=1000"`
 	}
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
 
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+	id, err := userIDParam(r)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldnt convert id string to int"), http.StatusBadRequest)
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
 		return
 	}
-	err = app.addPoint(requestPayload.Points, id)
+	err = app.addPoint(r.Context(), requestPayload.Points, id, taskCustom)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't add points to the user"), http.StatusBadRequest)
+		app.errorJSON(w, r, fmt.Errorf("couldn't add points to the user: %w", err))
 		return
 	}
 
@@ -286,106 +324,160 @@ func (app *Config) completeTask(w http.ResponseWriter, r *http.Request) {
 		Message: fmt.Sprintf("complete task worked for user with id %d, added points %d ", id, requestPayload.Points),
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// completeTelegramSign completes various task and adding some point to the user
-func (app *Config) completeTelegramSign(w http.ResponseWriter, r *http.Request) {
+// retrieveOne retrieves one user from the database by id with their check-in streak
+func (app *Config) retrieveOne(w http.ResponseWriter, r *http.Request) {
 
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	user, err := app.Repo.GetOne(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't convert id string to int"), http.StatusBadRequest)
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch user: %w", err))
 		return
 	}
-	err = app.addPoint(50, id)
+	streak, err := app.Repo.Streak(r.Context(), id, app.checkinRules())
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't add points to the user"), http.StatusBadRequest)
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch streak: %w", err))
 		return
 	}
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("complete telegram sign worked for user with id %d, added points %d ", id, 50),
+		Message: fmt.Sprintf("Retrieved one user from the database"),
+		Data:    userStatus{User: user, Streak: *streak},
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
-}
+	app.writeJSON(w, http.StatusOK, payload)
 
-// completeXSign completes various task and adding some point to the user
-func (app *Config) completeXSign(w http.ResponseWriter, r *http.Request) {
+}
 
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+// setTimezone changes the timezone the days and weeks of the task limits of the user are
+// counted in. Users change their own timezone once every TimezoneEvery, so moving between
+// timezones can't start a new day of the limits early more often. Admins change the
+// timezone of everyone else without the limit.
+func (app *Config) setTimezone(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Timezone string `json:"timezone" validate:"required,max=64,timezone"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't convert id string to int"), http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
-	err = app.addPoint(75, id)
+	id, err := userIDParam(r)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't add points to the user"), http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
-
-	payload := jsonResponse{
-		Error:   false,
-		Message: fmt.Sprintf("complete X sign worked for user with id %d, added points %d ", id, 75),
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
-}
-
-// retrieveOne retrieves one user from the database by id
-func (app *Config) retrieveOne(w http.ResponseWriter, r *http.Request) {
-
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		app.errorJSON(w, errors.New("couldnt convert id string to int"), http.StatusBadRequest)
+	every := app.Settings.TimezoneEvery
+	if callerID(r.Context()) != id {
+		every = 0
+	}
+	if err := app.Repo.SetTimezone(r.Context(), id, requestPayload.Timezone, every); err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't update timezone: %w", err))
 		return
 	}
-	user, err := app.Repo.GetOne(id)
+	user, err := app.Repo.GetOne(data.WithPrimary(r.Context()), id)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't fetch user"), http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("Retrieved one user from the database"),
+		Message: fmt.Sprintf("Set timezone of user %d to %s", id, user.Timezone),
 		Data:    user,
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
-
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
 // redeemReferrer redeems referrer for the owner of the referrer and for the user, who used it base on id and referrer
 func (app *Config) redeemReferrer(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Referrer string `json:"referrer"`
+		Referrer string `json:"referrer" validate:"required,max=255"`
 	}
-	idStr := chi.URLParam(r, "id")
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
-	id, err := strconv.Atoi(idStr)
+	id, err := userIDParam(r)
 	if err != nil {
-		app.errorJSON(w, errors.New("error during converting to string"), http.StatusBadRequest)
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
 		return
 	}
-	err = app.Repo.RedeemReferrer(id, requestPayload.Referrer)
+	err = app.Repo.RedeemReferrer(r.Context(), id, requestPayload.Referrer)
 	if err != nil {
-		app.errorJSON(w, errors.New("couldn't redeem referrer"), http.StatusBadRequest)
+		app.errorJSON(w, r, fmt.Errorf("couldn't redeem referrer: %w", err))
 		return
 	}
+	pointsAwarded.WithLabelValues(taskReferral).Add(data.ReferrerBonus + data.RefereeBonus)
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Referrer redeemed"),
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.writeJSON(w, http.StatusOK, payload)
+
+}
+
+// pointHistory returns one page of the points ledger of a user, newest first. Users see their
+// own history, admins the history of everyone.
+func (app *Config) pointHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	page, err := historyPage(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if _, err := app.Repo.GetOne(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	transactions, err := app.Repo.PointHistory(r.Context(), id, page)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch point history: %w", err))
+		return
+	}
+
+	history := struct {
+		Transactions []data.Transaction `json:"transactions"`
+		NextBefore   int64              `json:"next_before,omitempty"`
+	}{Transactions: transactions}
+	if len(transactions) == page.Limit {
+		history.NextBefore = transactions[len(transactions)-1].ID
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Fetched point history of user %d", id),
+		Data:    history,
+	}
 
+	app.writeJSON(w, http.StatusOK, payload)
 }
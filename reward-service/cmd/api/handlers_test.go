@@ -0,0 +1,803 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reward-service/blob"
+	"reward-service/config"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testApp struct {
+	*Config
+	repo    *data.MemoryRepository
+	handler http.Handler
+}
+
+// newTestApp returns the application wired to an in-memory repository
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	settings := config.Default()
+	settings.JWTSecret = "test-secret"
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	repo := data.NewMemoryRepository()
+	blobs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &Config{
+		Repo:     repo,
+		Client:   http.DefaultClient,
+		Blobs:    blobs,
+		Settings: &settings,
+		Workers:  NewWorkers(logger),
+		Logger:   logger,
+	}
+
+	return &testApp{Config: app, repo: repo, handler: app.routes()}
+}
+
+// do sends a request with an optional JSON body and access token cookie
+func (ta *testApp) do(t *testing.T, method, path string, body any, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return ta.doWithHeaders(t, method, path, body, token, nil)
+}
+
+// doWithHeaders is do with additional request headers
+func (ta *testApp) doWithHeaders(t *testing.T, method, path string, body any, token string, headers http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	}
+
+	rec := httptest.NewRecorder()
+	ta.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// register creates a user directly in the repository and returns its id
+func (ta *testApp) register(t *testing.T, email, password string) int {
+	t.Helper()
+
+	id, err := ta.repo.Insert(context.Background(), data.User{Email: email, Password: password, Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// login authenticates through the API and returns the access token
+func (ta *testApp) login(t *testing.T, email, password string) string {
+	t.Helper()
+
+	rec := ta.do(t, http.MethodPost, "/authenticate", map[string]string{"email": email, "password": password}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login failed with %d: %s", rec.Code, rec.Body)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "access_token" {
+			return cookie.Value
+		}
+	}
+
+	t.Fatal("no access token cookie")
+	return ""
+}
+
+// admin stores an admin with the email and the password "pw" and returns their id and
+// access token
+func (ta *testApp) admin(t *testing.T, email string) (int, string) {
+	t.Helper()
+
+	id, err := ta.repo.Insert(context.Background(), data.User{Email: email, Password: "pw", Role: data.RoleAdmin, Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id, ta.login(t, email, "pw")
+}
+
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) jsonResponse {
+	t.Helper()
+
+	var resp jsonResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+
+	return resp
+}
+
+func (ta *testApp) score(t *testing.T, id int) int {
+	t.Helper()
+
+	user, err := ta.repo.GetOne(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.Score
+}
+
+func TestRegistrate(t *testing.T) {
+	ta := newTestApp(t)
+
+	rec := ta.do(t, http.MethodPost, "/registrate", map[string]any{
+		"email":    "new@example.com",
+		"password": "password",
+	}, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	user, err := ta.repo.GetByEmail(context.Background(), "new@example.com")
+	if err != nil {
+		t.Fatalf("user was not stored: %v", err)
+	}
+	if location := rec.Header().Get("Location"); location != "/users/"+strconv.Itoa(user.ID)+"/status" {
+		t.Errorf("Location = %q", location)
+	}
+
+	rec = ta.do(t, http.MethodPost, "/registrate", map[string]any{"email": "new@example.com", "password": "password"}, "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("duplicate registration status = %d", rec.Code)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "valid@example.com", "pw")
+	// admins may send every request of the table, custom points included
+	_, token := ta.admin(t, "validator@example.com")
+	accept := http.Header{"Accept": {problemContentType}}
+
+	tests := []struct {
+		name  string
+		path  string
+		body  any
+		field string
+		code  string
+	}{
+		{"bad email", "/registrate", map[string]any{"email": "not-an-email", "password": "password"}, "email", "invalid_email"},
+		{"short password", "/registrate", map[string]any{"email": "a@example.com", "password": "short"}, "password", "too_short"},
+		{"missing password", "/registrate", map[string]any{"email": "a@example.com"}, "password", "required"},
+		// 40 characters, but bcrypt hashes at most 72 bytes
+		{"multibyte password", "/registrate", map[string]any{"email": "a@example.com", "password": strings.Repeat("я", 40)}, "password", "too_long"},
+		{"multibyte login", "/authenticate", map[string]any{"email": "a@example.com", "password": strings.Repeat("я", 40)}, "password", "too_long"},
+		{"own score", "/registrate", map[string]any{"email": "a@example.com", "password": "password", "score": 1000}, "score", "read_only"},
+		{"unknown field", "/registrate", map[string]any{"email": "a@example.com", "password": "password", "nickname": "a"}, "nickname", "unknown_field"},
+		{"negative points", "/users/" + strconv.Itoa(id) + "/task/complete", map[string]int{"points": -5}, "points", "out_of_range"},
+		{"missing referrer", "/users/" + strconv.Itoa(id) + "/referrer", map[string]string{}, "referrer", "required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ta.doWithHeaders(t, http.MethodPost, tt.path, tt.body, token, accept)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var p problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Errors) == 0 || p.Errors[0].Field != tt.field || p.Errors[0].Code != tt.code {
+				t.Errorf("errors = %+v, want %s %s", p.Errors, tt.field, tt.code)
+			}
+		})
+	}
+
+	if _, err := ta.repo.GetByEmail(context.Background(), "a@example.com"); err == nil {
+		t.Error("an invalid registration was stored")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ta := newTestApp(t)
+	ta.register(t, "ann@example.com", "right")
+
+	if token := ta.login(t, "ann@example.com", "right"); token == "" {
+		t.Error("empty access token")
+	}
+
+	for _, email := range []string{"ann@example.com", "nobody@example.com"} {
+		rec := ta.do(t, http.MethodPost, "/authenticate", map[string]string{"email": email, "password": "wrong"}, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d", email, rec.Code)
+		}
+		if resp := decodeResponse(t, rec); !resp.Error || resp.Message != "invalid credentials" {
+			t.Errorf("%s: response = %+v", email, resp)
+		}
+	}
+}
+
+func TestInactiveUser(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "gone@example.com", "pw")
+	token := ta.login(t, "gone@example.com", "pw")
+
+	user, err := ta.repo.GetOne(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Active = 0
+	if err := ta.repo.Update(context.Background(), *user); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := ta.do(t, http.MethodPost, "/authenticate", map[string]string{"email": "gone@example.com", "password": "pw"}, "")
+	if rec.Code != http.StatusForbidden || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("login of a deactivated user status = %d, cookies %v", rec.Code, rec.Result().Cookies())
+	}
+	rec = ta.do(t, http.MethodGet, "/users/leaderboard", nil, token)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("token of a deactivated user status = %d", rec.Code)
+	}
+
+	if err := ta.repo.DeleteByID(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	rec = ta.do(t, http.MethodGet, "/users/leaderboard", nil, token)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("token of a deleted user status = %d", rec.Code)
+	}
+}
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	ta := newTestApp(t)
+
+	for _, token := range []string{"", "not-a-jwt"} {
+		rec := ta.do(t, http.MethodGet, "/users/leaderboard", nil, token)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d", token, rec.Code)
+		}
+	}
+}
+
+func TestCompleteTasks(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "tasks@example.com", "pw")
+	token := ta.login(t, "tasks@example.com", "pw")
+	base := "/users/" + strconv.Itoa(id)
+
+	steps := []struct {
+		path string
+		body any
+		want int
+	}{
+		{base + "/task/telegramSign", nil, 50},
+		{base + "/task/XSign", nil, 125},
+	}
+	for _, step := range steps {
+		rec := ta.do(t, http.MethodPost, step.path, step.body, token)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", step.path, rec.Code, rec.Body)
+		}
+		if score := ta.score(t, id); score != step.want {
+			t.Errorf("%s: score = %d, want %d", step.path, score, step.want)
+		}
+	}
+
+	rec := ta.do(t, http.MethodPost, "/users/abc/task/telegramSign", nil, token)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid id status = %d", rec.Code)
+	}
+
+	// users complete only their own tasks, and custom points are credited by admins
+	other := ta.register(t, "bystander@example.com", "pw")
+	for _, path := range []string{"/task/telegramSign", "/tasks/x/complete"} {
+		if rec := ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(other)+path, nil, token); rec.Code != http.StatusForbidden {
+			t.Errorf("%s of another user = %d", path, rec.Code)
+		}
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/task/complete", map[string]int{"points": 10}, token); rec.Code != http.StatusForbidden {
+		t.Errorf("custom points by a user = %d", rec.Code)
+	}
+	if score := ta.score(t, other); score != 0 {
+		t.Errorf("score of the other user = %d, want 0", score)
+	}
+
+	_, adminToken := ta.admin(t, "taskmaster@example.com")
+	if rec := ta.do(t, http.MethodPost, base+"/task/complete", map[string]int{"points": 10}, adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("custom points by an admin = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 135 {
+		t.Errorf("score = %d, want 135", score)
+	}
+
+	rec = ta.do(t, http.MethodPost, "/users/999/task/telegramSign", nil, adminToken)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing user status = %d", rec.Code)
+	}
+}
+
+func TestRetrieveOneAndLeaderboard(t *testing.T) {
+	ta := newTestApp(t)
+	low := ta.register(t, "low@example.com", "pw")
+	high := ta.register(t, "high@example.com", "pw")
+	ta.repo.AddPoints(context.Background(), high, 100, data.PointChange{Source: data.SourceAdmin})
+	token := ta.login(t, "low@example.com", "pw")
+
+	rec := ta.do(t, http.MethodGet, "/users/"+strconv.Itoa(low)+"/status", nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = ta.do(t, http.MethodGet, "/users/leaderboard", nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("leaderboard status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data []data.User `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 2 || resp.Data[0].ID != high {
+		t.Errorf("leaderboard = %+v", resp.Data)
+	}
+
+	rec = ta.do(t, http.MethodGet, "/users/999/status", nil, token)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing user status = %d", rec.Code)
+	}
+}
+
+func TestRedeemReferrer(t *testing.T) {
+	ta := newTestApp(t)
+	owner, err := ta.repo.Insert(context.Background(), data.User{Email: "owner@example.com", Password: "pw", Active: 1, Referrer: "OWNER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	referee := ta.register(t, "referee@example.com", "pw")
+	token := ta.login(t, "referee@example.com", "pw")
+
+	rec := ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(referee)+"/referrer", map[string]string{"referrer": "OWNER"}, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if ta.score(t, owner) != data.ReferrerBonus || ta.score(t, referee) != data.RefereeBonus {
+		t.Errorf("scores after redemption: owner %d, referee %d", ta.score(t, owner), ta.score(t, referee))
+	}
+
+	rec = ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(referee)+"/referrer", map[string]string{"referrer": "OWNER"}, token)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("second redemption status = %d", rec.Code)
+	}
+
+	other := ta.register(t, "other@example.com", "pw")
+	rec = ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(other)+"/referrer", map[string]string{"referrer": "OWNER"}, token)
+	if rec.Code != http.StatusForbidden || ta.score(t, other) != 0 {
+		t.Errorf("redemption for another user status = %d, score %d", rec.Code, ta.score(t, other))
+	}
+
+	token = ta.login(t, "other@example.com", "pw")
+	rec = ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(other)+"/referrer", map[string]string{"referrer": "UNKNOWN"}, token)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown referrer status = %d", rec.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	ta := newTestApp(t)
+
+	rec := ta.do(t, http.MethodGet, "/healthz", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "problem@example.com", "pw")
+	token := ta.login(t, "problem@example.com", "pw")
+	accept := http.Header{"Accept": {"application/problem+json, application/json;q=0.5"}}
+
+	rec := ta.doWithHeaders(t, http.MethodGet, "/users/999/status", nil, token, accept)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var p problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "user_not_found" || p.Status != http.StatusNotFound || p.Instance != "/users/999/status" || p.RequestID == "" {
+		t.Errorf("problem = %+v", p)
+	}
+
+	_, adminToken := ta.admin(t, "problem-admin@example.com")
+	rec = ta.doWithHeaders(t, http.MethodPost, "/users/"+strconv.Itoa(id)+"/task/complete", map[string]string{"points": "many"}, adminToken, accept)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid field status = %d", rec.Code)
+	}
+	p = problem{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != "validation_failed" || len(p.Errors) != 1 || p.Errors[0].Field != "points" {
+		t.Errorf("problem = %+v", p)
+	}
+
+	// clients not asking for problem details keep getting the envelope
+	rec = ta.do(t, http.MethodGet, "/users/999/status", nil, token)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("envelope Content-Type = %q", ct)
+	}
+	if resp := decodeResponse(t, rec); !resp.Error {
+		t.Errorf("envelope = %+v", resp)
+	}
+}
+
+func TestPointHistory(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "history@example.com", "pw")
+	ta.register(t, "nosy@example.com", "pw")
+	_, err := ta.repo.Insert(context.Background(), data.User{Email: "admin@example.com", Password: "pw", Role: data.RoleAdmin, Active: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ta.login(t, "history@example.com", "pw")
+	adminToken := ta.login(t, "admin@example.com", "pw")
+	base := "/users/" + strconv.Itoa(id)
+
+	ta.do(t, http.MethodPost, base+"/task/telegramSign", nil, token)
+	ta.do(t, http.MethodPost, base+"/task/XSign", nil, token)
+	ta.do(t, http.MethodPost, base+"/task/complete", map[string]int{"points": 10}, adminToken)
+
+	var resp struct {
+		Data struct {
+			Transactions []data.Transaction `json:"transactions"`
+			NextBefore   int64              `json:"next_before"`
+		} `json:"data"`
+	}
+	rec := ta.do(t, http.MethodGet, base+"/transactions?limit=2", nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	page := resp.Data.Transactions
+	if len(page) != 2 || page[0].Delta != 10 || page[1].Delta != 75 || page[1].Reference != taskX || page[1].ActorID != id {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	rec = ta.do(t, http.MethodGet, base+"/transactions?limit=2&before="+strconv.FormatInt(resp.Data.NextBefore, 10), nil, token)
+	resp.Data.Transactions, resp.Data.NextBefore = nil, 0
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Transactions) != 1 || resp.Data.Transactions[0].Balance != 50 || resp.Data.NextBefore != 0 {
+		t.Errorf("second page = %+v", resp.Data)
+	}
+
+	if rec := ta.do(t, http.MethodGet, base+"/transactions", nil, ta.login(t, "nosy@example.com", "pw")); rec.Code != http.StatusForbidden {
+		t.Errorf("other user status = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodGet, base+"/transactions", nil, adminToken); rec.Code != http.StatusOK {
+		t.Errorf("admin status = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodGet, "/users/999/transactions", nil, adminToken); rec.Code != http.StatusNotFound {
+		t.Errorf("missing user status = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodGet, base+"/transactions?limit=1000", nil, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("limit too large status = %d", rec.Code)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "retry@example.com", "pw")
+	// keys are scoped to the caller, the admin crediting the custom points
+	adminID, token := ta.admin(t, "retrier@example.com")
+	path := "/users/" + strconv.Itoa(id) + "/task/complete"
+	withKey := func(key string) http.Header {
+		return http.Header{idempotencyKeyHeader: {key}}
+	}
+
+	first := ta.doWithHeaders(t, http.MethodPost, path, map[string]int{"points": 10}, token, withKey("k1"))
+	if first.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", first.Code, first.Body)
+	}
+
+	retry := ta.doWithHeaders(t, http.MethodPost, path, map[string]int{"points": 10}, token, withKey("k1"))
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want the first response %s", retry.Code, retry.Body, first.Body)
+	}
+	if retry.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("retry isn't marked as replayed")
+	}
+	if score := ta.score(t, id); score != 10 {
+		t.Errorf("score after the retry = %d, want 10", score)
+	}
+
+	rec := ta.doWithHeaders(t, http.MethodPost, path, map[string]int{"points": 20}, token, withKey("k1"))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "different request") {
+		t.Errorf("reused key = %d: %s", rec.Code, rec.Body)
+	}
+
+	// a duplicate of a request still in flight is rejected instead of applied
+	_, err := ta.repo.ReserveIdempotencyKey(context.Background(), adminID, "k2", fingerprint(httptest.NewRequest(http.MethodPost, path, nil), []byte(`{"points":5}`)), time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = ta.doWithHeaders(t, http.MethodPost, path, map[string]int{"points": 5}, token, withKey("k2"))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("in-flight duplicate = %d: %s", rec.Code, rec.Body)
+	}
+
+	// the request holding k4 crashed and its lease is over, a retry applies it
+	_, err = ta.repo.ReserveIdempotencyKey(context.Background(), adminID, "k4", fingerprint(httptest.NewRequest(http.MethodPost, path, nil), []byte(`{"points":5}`)), time.Now(), -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = ta.doWithHeaders(t, http.MethodPost, path, map[string]int{"points": 5}, token, withKey("k4"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("retry after the lease = %d: %s", rec.Code, rec.Body)
+	}
+
+	// failed requests are stored too, a client error doesn't become a success on retry
+	for range 2 {
+		rec = ta.doWithHeaders(t, http.MethodPost, path, map[string]int{"points": 0}, token, withKey("k3"))
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("invalid request = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	if score := ta.score(t, id); score != 15 {
+		t.Errorf("score = %d, want 15", score)
+	}
+
+	// the headers set by the handler are replayed, the ones set before it belong to each
+	// request
+	created := ta.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ta.writeJSON(w, http.StatusCreated, jsonResponse{Message: "created"}, http.Header{"Location": {"/tasks/quiz"}})
+	}))
+	for i := range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, "k5")
+		req = req.WithContext(context.WithValue(req.Context(), userIDKey, adminID))
+		rec := httptest.NewRecorder()
+		rec.Header().Set("X-Request-Id", strconv.Itoa(i))
+		created.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/tasks/quiz" || rec.Header().Get("X-Request-Id") != strconv.Itoa(i) {
+			t.Errorf("request %d = %d %v", i, rec.Code, rec.Header())
+		}
+		if replayed := rec.Header().Get(idempotentReplayedHeader) == "true"; replayed != (i == 1) {
+			t.Errorf("request %d replayed = %v", i, replayed)
+		}
+	}
+}
+
+func TestTaskCatalog(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "catalog@example.com", "pw")
+	token := ta.login(t, "catalog@example.com", "pw")
+	if _, err := ta.repo.Insert(context.Background(), data.User{Email: "admin@example.com", Password: "pw", Role: data.RoleAdmin, Active: 1}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := ta.login(t, "admin@example.com", "pw")
+
+	discord := map[string]any{"code": "discord", "title": "Join Discord", "points": 30}
+	if rec := ta.do(t, http.MethodPost, "/tasks", discord, token); rec.Code != http.StatusForbidden {
+		t.Errorf("create by a user = %d", rec.Code)
+	}
+	rec := ta.do(t, http.MethodPost, "/tasks", discord, adminToken)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/tasks/discord" {
+		t.Fatalf("create = %d %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPost, "/tasks", discord, adminToken); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate create = %d", rec.Code)
+	}
+	invalid := map[string]any{"code": "no spaces", "title": "t", "points": 1,
+		"starts_at": "2025-03-02T00:00:00Z", "ends_at": "2025-03-01T00:00:00Z"}
+	if rec := ta.do(t, http.MethodPost, "/tasks", invalid, adminToken); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid create = %d: %s", rec.Code, rec.Body)
+	}
+
+	base := "/users/" + strconv.Itoa(id)
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/discord/complete", nil, token); rec.Code != http.StatusOK {
+		t.Fatalf("complete = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/task/telegramSign", nil, token); rec.Code != http.StatusOK {
+		t.Fatalf("alias = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 80 {
+		t.Errorf("score = %d, want 80", score)
+	}
+
+	// points and the active window come from the catalog
+	update := map[string]any{"title": "Join Discord", "points": 30, "ends_at": "2020-01-01T00:00:00Z"}
+	if rec := ta.do(t, http.MethodPut, "/tasks/discord", update, adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("update = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/discord/complete", nil, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("complete of an ended task = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/unknown/complete", nil, token); rec.Code != http.StatusNotFound {
+		t.Errorf("complete of an unknown task = %d", rec.Code)
+	}
+
+	// the completed task only ends, deleting it would let users complete it again
+	if rec := ta.do(t, http.MethodDelete, "/tasks/discord", nil, adminToken); rec.Code != http.StatusConflict {
+		t.Errorf("delete of a completed task = %d", rec.Code)
+	}
+	unused := map[string]any{"code": "unused", "title": "Unused", "points": 5}
+	if rec := ta.do(t, http.MethodPost, "/tasks", unused, adminToken); rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodDelete, "/tasks/unused", nil, adminToken); rec.Code != http.StatusOK {
+		t.Errorf("delete = %d", rec.Code)
+	}
+	var tasks struct {
+		Data []data.Task `json:"data"`
+	}
+	rec = ta.do(t, http.MethodGet, "/tasks", nil, token)
+	if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks.Data) != len(data.DefaultTasks)+1 {
+		t.Errorf("tasks after delete = %+v", tasks.Data)
+	}
+}
+
+func TestTaskCompletions(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "once@example.com", "pw")
+	token := ta.login(t, "once@example.com", "pw")
+	base := "/users/" + strconv.Itoa(id)
+
+	if rec := ta.do(t, http.MethodPost, base+"/task/XSign", nil, token); rec.Code != http.StatusOK {
+		t.Fatalf("first completion = %d: %s", rec.Code, rec.Body)
+	}
+	rec := ta.doWithHeaders(t, http.MethodPost, base+"/tasks/x/complete", nil, token, http.Header{"Accept": {problemContentType}})
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "task_already_completed") {
+		t.Errorf("second completion = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 75 {
+		t.Errorf("score = %d, want 75", score)
+	}
+
+	var resp struct {
+		Data struct {
+			Completed []data.UserTask `json:"completed"`
+			Available []data.UserTask `json:"available"`
+		} `json:"data"`
+	}
+	rec = ta.do(t, http.MethodGet, base+"/tasks", nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	completed, available := resp.Data.Completed, resp.Data.Available
+	if len(completed) != 1 || completed[0].Code != "x" || completed[0].Completions != 1 || completed[0].LastCompletedAt == nil {
+		t.Errorf("completed = %+v", completed)
+	}
+	if len(available) != 1 || available[0].Code != "telegram" {
+		t.Errorf("available = %+v", available)
+	}
+
+	other := ta.register(t, "nosy@example.com", "pw")
+	if rec := ta.do(t, http.MethodGet, "/users/"+strconv.Itoa(other)+"/tasks", nil, token); rec.Code != http.StatusForbidden {
+		t.Errorf("tasks of another user = %d", rec.Code)
+	}
+}
+
+func TestTaskLimits(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "sharer@example.com", "pw")
+	token := ta.login(t, "sharer@example.com", "pw")
+	if _, err := ta.repo.Insert(context.Background(), data.User{Email: "limits@example.com", Password: "pw", Role: data.RoleAdmin, Active: 1}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := ta.login(t, "limits@example.com", "pw")
+
+	once := map[string]any{"code": "once", "title": "Once", "points": 10, "daily_limit": 3}
+	if rec := ta.do(t, http.MethodPost, "/tasks", once, adminToken); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "daily_limit") {
+		t.Errorf("limit of a task completed once = %d: %s", rec.Code, rec.Body)
+	}
+	share := map[string]any{"code": "share", "title": "Share a post", "points": 10, "repeatable": true, "daily_limit": 1}
+	if rec := ta.do(t, http.MethodPost, "/tasks", share, adminToken); rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+	}
+
+	base := "/users/" + strconv.Itoa(id)
+	if rec := ta.do(t, http.MethodPut, base+"/timezone", map[string]string{"timezone": "Mars/Olympus"}, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown timezone = %d: %s", rec.Code, rec.Body)
+	}
+	rec := ta.do(t, http.MethodPut, base+"/timezone", map[string]string{"timezone": "America/New_York"}, token)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"timezone":"America/New_York"`) {
+		t.Fatalf("set timezone = %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/share/complete", nil, token); rec.Code != http.StatusOK {
+		t.Fatalf("first completion = %d: %s", rec.Code, rec.Body)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tomorrow := data.PeriodsAt(time.Now(), newYork).NextDay
+
+	rec = ta.doWithHeaders(t, http.MethodPost, base+"/tasks/share/complete", nil, token, http.Header{"Accept": {problemContentType}})
+	var p problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusTooManyRequests || p.Code != "task_on_cooldown" || p.AvailableAt == nil || !p.AvailableAt.Equal(tomorrow) {
+		t.Errorf("completion over the limit = %d: %s", rec.Code, rec.Body)
+	}
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if wait := time.Until(tomorrow); err != nil || retryAfter < int(wait.Seconds()) || retryAfter > int(wait.Seconds())+1 {
+		t.Errorf("Retry-After = %q, want %v", rec.Header().Get("Retry-After"), wait)
+	}
+
+	var resp struct {
+		Data struct {
+			Completed []data.UserTask `json:"completed"`
+		} `json:"data"`
+	}
+	rec = ta.do(t, http.MethodGet, base+"/tasks", nil, token)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	completed := resp.Data.Completed
+	if len(completed) != 1 || completed[0].CompletedToday != 1 || completed[0].AvailableAt == nil || !completed[0].AvailableAt.Equal(tomorrow) {
+		t.Errorf("completed = %+v", completed)
+	}
+	if score := ta.score(t, id); score != 10 {
+		t.Errorf("score = %d, want 10", score)
+	}
+
+	// moving east would start a new day of the limit, the timezone changed recently
+	rec = ta.doWithHeaders(t, http.MethodPut, base+"/timezone", map[string]string{"timezone": "Pacific/Kiritimati"}, token, http.Header{"Accept": {problemContentType}})
+	p = problem{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusTooManyRequests || p.Code != "timezone_changed_recently" || p.AvailableAt == nil || time.Until(*p.AvailableAt) < ta.Settings.TimezoneEvery-time.Minute {
+		t.Errorf("second timezone change = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/share/complete", nil, token); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("completion after the refused change = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPut, base+"/timezone", map[string]string{"timezone": "America/New_York"}, token); rec.Code != http.StatusOK {
+		t.Errorf("setting the same timezone again = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPut, base+"/timezone", map[string]string{"timezone": "Europe/Berlin"}, adminToken); rec.Code != http.StatusOK {
+		t.Errorf("timezone change by an admin = %d: %s", rec.Code, rec.Body)
+	}
+}
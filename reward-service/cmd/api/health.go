@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"reward-service/data"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type componentStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// healthz reports that the process is alive, without looking at any dependency
+func (app *Config) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz checks every dependency and reports whether the replica can serve traffic
+func (app *Config) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) error{
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+		"workers": func(ctx context.Context) error {
+			return app.Workers.Check()
+		},
+	}
+
+	report := readinessReport{
+		Status:     "ok",
+		Components: make(map[string]componentStatus, len(checks)),
+	}
+	for name, check := range checks {
+		status := runCheck(r.Context(), check)
+		if status.Status != "ok" {
+			report.Status = "unavailable"
+		}
+		report.Components[name] = status
+	}
+
+	statusCode := http.StatusOK
+	if report.Status != "ok" {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	app.writeJSON(w, statusCode, report)
+}
+
+// runCheck runs one check with its own timeout and measures how long it took
+func runCheck(ctx context.Context, check func(ctx context.Context) error) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	status := componentStatus{
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "failing"
+		status.Error = err.Error()
+	}
+
+	return status
+}
+
+// checkDatabase pings the database pool
+func (app *Config) checkDatabase(ctx context.Context) error {
+	return app.DB.PingContext(ctx)
+}
+
+// checkMigrations makes sure the schema was migrated and no migration is left half applied
+func (app *Config) checkMigrations(ctx context.Context) error {
+	var version int64
+	var dirty bool
+	err := app.DB.QueryRowContext(ctx, "select version, dirty from schema_migrations limit 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no migration applied")
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration %d is dirty", version)
+	}
+
+	latest, err := data.LatestMigration(data.BackendOf(app.Settings.DSN))
+	if err != nil {
+		return err
+	}
+	if uint(version) < latest {
+		return fmt.Errorf("schema at version %d, %d is pending", version, latest)
+	}
+
+	return nil
+}
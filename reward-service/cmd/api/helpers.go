@@ -1,36 +1,68 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"reward-service/data"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// maxRequestBytes is the largest request body the service reads
+const maxRequestBytes = 1048576
+
+// errInvalidCredentials is returned for an unknown email and for a wrong password alike
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// errAccountInactive is returned when a deactivated user logs in or uses a token issued
+// before the deactivation
+var errAccountInactive = errors.New("account is deactivated")
+
+// errForbidden is returned when a user operates on another user without being an admin
+var errForbidden = errors.New("not allowed to access this user")
+
 type jsonResponse struct {
 	Error   bool        `json:"error"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
 }
 
-// readJSON tries to read the body of a request and converts it into JSON
+// readJSON tries to read the body of a request and converts it into JSON. Unknown fields are
+// rejected and the payload is validated against its validate struct tags.
 func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any) error {
-	maxBytes := 1048576
-
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
 
 	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
 	err := dec.Decode(data)
+	if field, ok := unknownField(err); ok {
+		return invalidFields(field)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return invalidFields(fieldError{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: "must be a JSON " + typeErr.Value + " of type " + typeErr.Type.String(),
+		})
+	}
 	if err != nil {
-		return err
+		return invalidRequest("body must be valid JSON: " + err.Error())
 	}
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must have only a single JSON value")
+		return invalidRequest("body must have only a single JSON value")
 	}
 
-	return nil
+	return validatePayload(data)
 }
 
 // writeJSON takes a response status code and arbitrary data and writes a json response to the client
@@ -46,7 +78,9 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data any, header
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -56,17 +90,127 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data any, header
 	return nil
 }
 
-// errorJSON takes an error, and optionally a response status code, and generates and sends a json error response
-func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+// errorJSON takes an error, and optionally a response status code, and generates and sends a json error response.
+// Without a status code it is derived from the error by classify. Clients accepting application/problem+json
+// get RFC 7807 problem details instead of the jsonResponse envelope. Server errors are logged and their
+// details are kept from the client.
+func (app *Config) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
+	statusCode, code := classify(err)
 
-	if len(status) > 0 {
+	if len(status) > 0 && status[0] != statusCode {
 		statusCode = status[0]
+		code = codeForStatus(statusCode)
+	}
+
+	message := err.Error()
+	if statusCode >= http.StatusInternalServerError {
+		loggerFrom(r.Context()).Error("Request failed", "status", statusCode, "error", err)
+		message = http.StatusText(statusCode)
+	}
+
+	headers := http.Header{}
+	headers.Set("Vary", "Accept")
+	availableAt := availableAgain(err)
+	if availableAt != nil {
+		headers.Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(time.Until(*availableAt).Seconds())))))
+	}
+
+	if wantsProblem(r) {
+		headers.Set("Content-Type", problemContentType)
+		return app.writeJSON(w, statusCode, newProblem(r, err, statusCode, code, message), headers)
 	}
 
 	var payload jsonResponse
 	payload.Error = true
-	payload.Message = err.Error()
+	payload.Message = message
+	if availableAt != nil {
+		payload.Data = map[string]time.Time{"available_at": *availableAt}
+	}
+
+	return app.writeJSON(w, statusCode, payload, headers)
+}
+
+// userIDParam returns the user id of the route
+func userIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return 0, invalidRequest("user id must be a number")
+	}
+
+	return id, nil
+}
+
+// callerID returns the id of the authenticated user, zero outside of authTokenMiddleware
+func callerID(ctx context.Context) int {
+	id, _ := ctx.Value(userIDKey).(int)
+	return id
+}
+
+// authorizeUser lets the authenticated user access the user with the id when it is
+// their own account or when they are an admin
+func (app *Config) authorizeUser(ctx context.Context, id int) error {
+	caller := callerID(ctx)
+	if caller == id {
+		return nil
+	}
+
+	user, err := app.Repo.GetOne(ctx, caller)
+	if errors.Is(err, data.ErrNotFound) {
+		return errForbidden
+	}
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin() {
+		return errForbidden
+	}
+
+	return nil
+}
+
+// requireAdmin lets only admins through, it runs after authTokenMiddleware
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.Repo.GetOne(r.Context(), callerID(r.Context()))
+		if errors.Is(err, data.ErrNotFound) || (err == nil && !user.IsAdmin()) {
+			err = errForbidden
+		}
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// historyPage reads the limit and before query parameters of a history request
+func historyPage(r *http.Request) (data.HistoryPage, error) {
+	page := data.HistoryPage{Limit: data.DefaultHistoryLimit}
+	var fields []fieldError
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > data.MaxHistoryLimit {
+			fields = append(fields, fieldError{
+				Field:   "limit",
+				Code:    "out_of_range",
+				Message: fmt.Sprintf("must be a number from 1 to %d", data.MaxHistoryLimit),
+			})
+		}
+		page.Limit = limit
+	}
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before < 1 {
+			fields = append(fields, fieldError{Field: "before", Code: "invalid", Message: "must be a transaction id"})
+		}
+		page.Before = before
+	}
+
+	if len(fields) > 0 {
+		return page, invalidFields(fields...)
+	}
 
-	return app.writeJSON(w, statusCode, payload)
+	return page, nil
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"reward-service/data"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// idempotencyKeyHeader carries the client chosen key of a point-mutating request
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed from an earlier request
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	// idempotencyCleanupInterval is how often the expired keys are deleted
+	idempotencyCleanupInterval = time.Hour
+	// idempotencyLeaseMargin is added to the request timeout for the lease of a key, once it
+	// is over the request can't be in flight any more and a retry takes the key over
+	idempotencyLeaseMargin = time.Minute
+)
+
+// errIdempotencyInProgress is returned while the first request with the same key is applied
+var errIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// errIdempotencyKeyReused is returned when the key was first sent with another request
+var errIdempotencyKeyReused = errors.New("the idempotency key was used for a different request")
+
+// idempotent applies a request sent with an Idempotency-Key once per user and key. Retries
+// within the configured ttl get the stored response of the first request, with its headers
+// and the Idempotent-Replayed header, while a retry arriving before the first request is complete
+// gets 409. Responses with a 5xx status aren't stored, so the request can be retried, and
+// the key of a request which never finished, because the service crashed, is free again
+// after its lease.
+// It runs after authTokenMiddleware, the keys are scoped to the caller.
+func (app *Config) idempotent(next http.Handler) http.Handler {
+	store, ok := app.Repo.(data.IdempotencyStore)
+	if !ok {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			app.errorJSON(w, r, invalidRequest("idempotency key must be at most 255 characters long"))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+		if err != nil {
+			app.errorJSON(w, r, invalidRequest("couldn't read the request body: "+err.Error()))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		caller, sum := callerID(r.Context()), fingerprint(r, body)
+		since := time.Now().Add(-app.Settings.IdempotencyTTL)
+		lease := app.Settings.RequestTimeout + idempotencyLeaseMargin
+		record, err := store.ReserveIdempotencyKey(r.Context(), caller, key, sum, since, lease)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+
+		switch {
+		case record == nil:
+			// the key is new, apply the request
+		case record.Fingerprint != sum:
+			idempotentRequests.WithLabelValues("mismatch").Inc()
+			app.errorJSON(w, r, errIdempotencyKeyReused)
+			return
+		case record.Response == nil:
+			idempotentRequests.WithLabelValues("in_progress").Inc()
+			app.errorJSON(w, r, errIdempotencyInProgress)
+			return
+		default:
+			idempotentRequests.WithLabelValues("replayed").Inc()
+			for name, values := range record.Response.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(record.Response.Status)
+			_, _ = w.Write(record.Response.Body)
+			return
+		}
+
+		// the key outlives the request, storing the response must not fail with its deadline
+		ctx := context.WithoutCancel(r.Context())
+		stored := false
+		defer func() {
+			if !stored {
+				if err := store.ReleaseIdempotencyKey(ctx, caller, key); err != nil {
+					app.Logger.Warn("Couldn't release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		// the headers set before the handler, like the request id, belong to this request only
+		before := w.Header().Clone()
+		var buf bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&buf)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError {
+			return
+		}
+
+		header := make(map[string][]string)
+		for name, values := range ww.Header() {
+			if !slices.Equal(before[name], values) {
+				header[name] = values
+			}
+		}
+		response := data.StoredResponse{Status: status, Header: header, Body: buf.Bytes()}
+		if err := store.CompleteIdempotencyKey(ctx, caller, key, response); err != nil {
+			app.Logger.Warn("Couldn't store the response of an idempotent request", "error", err)
+			return
+		}
+		stored = true
+		idempotentRequests.WithLabelValues("applied").Inc()
+	})
+}
+
+// fingerprint identifies the request a key was sent with, by its method, path and body
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// setupIdempotency starts the worker deleting the expired idempotency keys
+func (app *Config) setupIdempotency() {
+	store, ok := app.Repo.(data.IdempotencyStore)
+	if !ok {
+		app.Logger.Warn("The repository doesn't store idempotency keys, Idempotency-Key is ignored")
+		return
+	}
+
+	app.Workers.Go("idempotency-cleanup", func(ctx context.Context) {
+		ticker := time.NewTicker(idempotencyCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			deleted, err := store.DeleteIdempotencyKeys(ctx, time.Now().Add(-app.Settings.IdempotencyTTL))
+			if err != nil {
+				app.Logger.Warn("Couldn't delete expired idempotency keys", "error", err)
+				continue
+			}
+			app.Logger.Debug("Deleted expired idempotency keys", "count", deleted)
+		}
+	})
+}
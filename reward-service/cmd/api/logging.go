@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const redactedValue = "[REDACTED]"
+
+// suffixes of attribute keys whose values are never written to the logs
+var secretKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+type loggerKey struct{}
+
+// requestLog holds the logger of one request, middlewares further down the chain
+// can enrich it and the access log written at the end still sees the result
+type requestLog struct {
+	logger *slog.Logger
+}
+
+// newLogger builds the service logger with the given level and format ("text" or "json")
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// redactAttr hides secrets and masks email addresses before an attribute is written
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, secret := range secretKeys {
+		if strings.HasSuffix(key, secret) {
+			return slog.String(a.Key, redactedValue)
+		}
+	}
+
+	if a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(emailPattern.ReplaceAllStringFunc(a.Value.String(), maskEmail))
+	}
+
+	return a
+}
+
+// maskEmail keeps the first letter of the local part and the domain, e.g. j***@example.com
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return redactedValue
+	}
+
+	return email[:1] + "***" + email[at:]
+}
+
+// loggerFrom returns the request-scoped logger stored in ctx, or the default logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if rl, ok := ctx.Value(loggerKey{}).(*requestLog); ok {
+		return rl.logger
+	}
+
+	return slog.Default()
+}
+
+// withLoggerAttrs adds attributes to the request-scoped logger stored in ctx
+func withLoggerAttrs(ctx context.Context, args ...any) {
+	if rl, ok := ctx.Value(loggerKey{}).(*requestLog); ok {
+		rl.logger = rl.logger.With(args...)
+	}
+}
+
+// requestLogger stores a logger carrying the chi request ID in the request context
+// and writes one access log line per request
+func (app *Config) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rl := &requestLog{
+			logger: app.Logger.With("request_id", middleware.GetReqID(r.Context())),
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			rl.logger = rl.logger.With("trace_id", sc.TraceID().String())
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), loggerKey{}, rl)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		rl.logger.Info("request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+		)
+	})
+}
@@ -1,58 +1,202 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"reward-service/blob"
+	"reward-service/config"
 	"reward-service/data"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
-const webPort = "82"
-
 var counts int64
 
 type Config struct {
-	Repo   data.Repository
-	Client *http.Client
+	DB        *sql.DB
+	Replica   *sql.DB
+	Repo      data.Repository
+	Client    *http.Client
+	Verifiers map[string]TaskVerifier
+	Blobs     blob.Store
+	Settings  *config.Config
+	Workers   *Workers
+	Logger    *slog.Logger
 }
 
-// main starts the server and establishing connection to database
+// main loads the configuration and runs the requested command, the server by default
 func main() {
-	log.Println("Starting reward service")
+	settings, args, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("Couldn't load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	level, _ := settings.Level()
+	logger := newLogger(os.Stdout, level, settings.LogFormat)
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		err = runServer(ctx, settings, logger)
+	case "migrate":
+		err = runMigrate(settings, logger, args)
+	default:
+		err = fmt.Errorf("unknown command %q, expected serve or migrate", command)
+	}
+
+	if err != nil {
+		logger.Error("Reward service stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runServer starts the server and establishing connection to database
+func runServer(ctx context.Context, settings *config.Config, logger *slog.Logger) error {
+	if err := settings.ValidateServer(); err != nil {
+		return err
+	}
+	logger.Info("Starting reward service", "config", settings)
+
+	app := Config{
+		Settings: settings,
+		Workers:  NewWorkers(logger),
+		Logger:   logger,
+	}
+
+	shutdownTracing, err := app.setupTracing(ctx)
+	if err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+		defer cancel()
+		if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+			logger.Warn("Couldn't flush traces", "error", flushErr)
+		}
+	}()
 
 	// connect to DB
-	conn := connectToDB()
+	conn := connectToDB(ctx, logger, settings.DSN)
 	if conn == nil {
-		log.Panic("Can't connect to Postgres!")
+		return errors.New("can't connect to the database")
+	}
+
+	if settings.AutoMigrate {
+		if err := migrateUp(settings.DSN, logger); err != nil {
+			conn.Close()
+			return fmt.Errorf("applying migrations: %w", err)
+		}
 	}
 
 	// set up config
-	app := Config{
-		Client: &http.Client{},
+	app.DB = conn
+	app.Client = &http.Client{
+		Transport: tracingTransport(http.DefaultTransport),
+	}
+	blobs, err := blob.NewFileStore(settings.BlobDir)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("setting up the blob store: %w", err)
 	}
+	app.Blobs = blobs
 	app.setupRepo(conn)
+	app.setupIdempotency()
+	app.setupVerifiers()
+	if err := app.registerDBMetrics(); err != nil {
+		conn.Close()
+		return fmt.Errorf("registering database metrics: %w", err)
+	}
+	if settings.ReplicaDSN != "" {
+		if err := app.setupReplica(); err != nil {
+			conn.Close()
+			return fmt.Errorf("setting up the read replica: %w", err)
+		}
+	}
 
+	return app.serve(ctx)
+}
+
+// serve runs the HTTP server until ctx is cancelled, then drains in-flight requests,
+// stops the background workers and closes the database pool
+func (app *Config) serve(ctx context.Context) error {
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", webPort),
+		Addr:    fmt.Sprintf(":%s", app.Settings.WebPort),
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Panic(err)
+	serverErr := make(chan error, 1)
+	go func() {
+		app.Logger.Info("Listening", "port", app.Settings.WebPort)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serverErr:
+		app.Logger.Error("HTTP server stopped", "error", err)
+	case <-ctx.Done():
+		app.Logger.Info("Shutdown signal received")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.Settings.ShutdownTimeout)
+	defer cancel()
+
+	app.Logger.Info("Draining in-flight requests", "deadline", app.Settings.ShutdownTimeout)
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+		app.Logger.Warn("Couldn't drain requests in time, closing connections", "error", shutdownErr)
+		srv.Close()
 	}
+
+	app.Logger.Info("Stopping background workers")
+	if stopErr := app.Workers.Stop(shutdownCtx); stopErr != nil {
+		app.Logger.Warn("Background workers didn't stop in time", "error", stopErr)
+	}
+
+	app.Logger.Info("Closing database connections")
+	if closeErr := app.DB.Close(); closeErr != nil {
+		app.Logger.Error("Error closing database connections", "error", closeErr)
+	}
+	if app.Replica != nil {
+		if closeErr := app.Replica.Close(); closeErr != nil {
+			app.Logger.Error("Error closing read replica connections", "error", closeErr)
+		}
+	}
+
+	app.Logger.Info("Shutdown complete")
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-// // openDB establishes a connection to the PostgreSQL database using the provided Data Source Name (DSN)
+// openDB establishes a connection to the database using the provided Data Source Name (DSN)
 func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx/v4", dsn)
-	fmt.Println(db)
+	db, err := data.Open(dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -65,33 +209,36 @@ func openDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-// connectToDB connect to Postgres with provided dsn
-func connectToDB() *sql.DB {
-	dsn := "host=postgres port=5432 dbname=users user=postgres password=password"
-
+// connectToDB connects to the database with provided dsn, giving up early when ctx is cancelled
+func connectToDB(ctx context.Context, logger *slog.Logger, dsn string) *sql.DB {
 	for {
 		connection, err := openDB(dsn)
 		if err != nil {
-			log.Println("Postgres not yet ready ...")
+			logger.Warn("Database not yet ready ...", "error", err)
 			counts++
 		} else {
-			log.Println("Connected to Postgres!")
+			logger.Info("Connected to the database!")
 			return connection
 		}
 
 		if counts > 10 {
-			log.Println(err)
+			logger.Error("Giving up connecting to the database", "error", err)
 			return nil
 		}
 
-		log.Println("Backing off for two seconds....")
-		time.Sleep(2 * time.Second)
+		logger.Info("Backing off for two seconds....")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(2 * time.Second):
+		}
 		continue
 	}
 }
 
-// setupRepo sets new postgres repository
+// setupRepo sets the repository of the configured database backend
 func (app *Config) setupRepo(conn *sql.DB) {
-	db := data.NewPostgresRepository(conn)
-	app.Repo = db
+	backend := data.BackendOf(app.Settings.DSN)
+	observer := data.ChainObservers(traceQueries(backend), observeQuery)
+	app.Repo = data.NewRepository(app.Settings.DSN, conn, app.Settings.PoolOptions(), observer)
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reward-service/data"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "reward"
+
+// task labels of the pointsAwarded counter, the tasks of the catalog are labelled by
+// their code and telegram and x are the codes of the seeded tasks
+const (
+	taskTelegram = "telegram"
+	taskX        = "x"
+	taskCustom   = data.ReferenceCustomTask
+	taskReferral = "referral"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by method, chi route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method and chi route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of repository queries by operation and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "outcome"})
+
+	pointsAwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "points_awarded_total",
+		Help:      "Points awarded to users by task type.",
+	}, []string{"task"})
+
+	registrations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registrations_total",
+		Help:      "Number of successfully registered users.",
+	})
+
+	failedLogins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "failed_logins_total",
+		Help:      "Number of rejected authentication attempts.",
+	})
+
+	taskVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "task_verifications_total",
+		Help:      "Task verifications by verifier and result: verified, not_verified or pending.",
+	}, []string{"verifier", "result"})
+
+	taskSubmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "task_submissions_total",
+		Help:      "Task submissions reviewed by moderators by status: pending when sent, approved or rejected.",
+	}, []string{"status"})
+
+	idempotentRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "idempotent_requests_total",
+		Help:      "Requests sent with an Idempotency-Key by outcome: applied, replayed, in_progress or mismatch.",
+	}, []string{"outcome"})
+
+	replicaHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_replica_healthy",
+		Help:      "Whether reads go to the read replica (1) or to the primary because it is unhealthy (0).",
+	})
+)
+
+// registerDBMetrics exposes the connection pool statistics of the database
+func (app *Config) registerDBMetrics() error {
+	return prometheus.Register(collectors.NewDBStatsCollector(app.DB, "users"))
+}
+
+// metricsMiddleware counts requests and measures their latency per chi route pattern
+func (app *Config) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// observeQuery is the data.QueryObserver which records repository query durations
+func observeQuery(ctx context.Context, operation string) (context.Context, func(err error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		dbQueryDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reward-service/config"
+	"reward-service/data"
+	"strconv"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | version | force <version>"
+
+// runMigrate runs the migrate subcommand against the configured database
+func runMigrate(settings *config.Config, logger *slog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := data.NewMigrator(settings.DSN)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("steps %q is not a number", args[1])
+			}
+		}
+		err = migrator.Down(steps)
+	case "version":
+	case "force":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("version %q is not a number", args[1])
+		}
+		err = migrator.Force(version)
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	logger.Info("Migration state", "command", args[0], "version", version, "dirty", dirty)
+
+	return nil
+}
+
+// migrateUp applies the pending migrations, it is used when auto-migrate is enabled
+func migrateUp(dsn string, logger *slog.Logger) error {
+	migrator, err := data.NewMigrator(dsn)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	if err := migrator.Up(); err != nil {
+		return err
+	}
+
+	version, _, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	logger.Info("Database migrated", "version", version)
+
+	return nil
+}
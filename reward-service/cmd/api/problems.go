@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"reward-service/data"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 problem details object. Code is the stable machine-readable
+// error code clients should check instead of the human readable detail.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+	// AvailableAt is when a task on cooldown or the next check-in is available
+	AvailableAt *time.Time `json:"available_at,omitempty"`
+}
+
+// fieldError describes why one field of the request was rejected
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// requestError is a client error in the request itself, with the rejected fields if any
+type requestError struct {
+	message string
+	fields  []fieldError
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// invalidRequest returns the error of a malformed request
+func invalidRequest(message string) error {
+	return &requestError{message: message}
+}
+
+// invalidFields returns the error of a well-formed request with invalid fields, its message
+// lists them for the clients which don't read problem details
+func invalidFields(fields ...fieldError) error {
+	descriptions := make([]string, 0, len(fields))
+	for _, field := range fields {
+		descriptions = append(descriptions, field.Field+" "+field.Message)
+	}
+
+	return &requestError{message: "invalid request: " + strings.Join(descriptions, "; "), fields: fields}
+}
+
+// error codes of the domain errors, every error not listed is an internal error
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{data.ErrNotFound, http.StatusNotFound, "user_not_found"},
+	{data.ErrDuplicateEmail, http.StatusConflict, "duplicate_email"},
+	{data.ErrAlreadyRedeemed, http.StatusConflict, "referrer_already_redeemed"},
+	{data.ErrInsufficientPoints, http.StatusUnprocessableEntity, "insufficient_points"},
+	{data.ErrUnknownReferrer, http.StatusUnprocessableEntity, "unknown_referrer"},
+	{data.ErrTaskNotFound, http.StatusNotFound, "task_not_found"},
+	{data.ErrDuplicateTask, http.StatusConflict, "duplicate_task"},
+	{data.ErrTaskInUse, http.StatusConflict, "task_in_use"},
+	{data.ErrTaskInactive, http.StatusUnprocessableEntity, "task_inactive"},
+	{data.ErrTaskCompleted, http.StatusConflict, "task_already_completed"},
+	{data.ErrTaskCooldown, http.StatusTooManyRequests, "task_on_cooldown"},
+	{data.ErrCheckedIn, http.StatusConflict, "already_checked_in"},
+	{data.ErrTimezoneChanged, http.StatusTooManyRequests, "timezone_changed_recently"},
+	{data.ErrAccountTaken, http.StatusConflict, "account_already_used"},
+	{errTaskNotVerified, http.StatusUnprocessableEntity, "task_not_verified"},
+	{data.ErrSubmissionNotFound, http.StatusNotFound, "submission_not_found"},
+	{data.ErrSubmissionPending, http.StatusConflict, "submission_pending"},
+	{data.ErrSubmissionReviewed, http.StatusConflict, "submission_already_reviewed"},
+	{data.ErrQuestNotFound, http.StatusNotFound, "quest_not_found"},
+	{data.ErrDuplicateQuest, http.StatusConflict, "duplicate_quest"},
+	{data.ErrQuestInUse, http.StatusConflict, "quest_in_use"},
+	{data.ErrUnknownPrerequisite, http.StatusUnprocessableEntity, "unknown_prerequisite"},
+	{errReviewRequired, http.StatusUnprocessableEntity, "review_required"},
+	{errSubmissionNotAccepted, http.StatusUnprocessableEntity, "submission_not_accepted"},
+	{errInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{errAccountInactive, http.StatusForbidden, "account_inactive"},
+	{errForbidden, http.StatusForbidden, "forbidden"},
+	{errIdempotencyInProgress, http.StatusConflict, "idempotency_key_in_progress"},
+	{errIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout"},
+}
+
+// statusCodes are the error codes of the errors which are only known by their status code
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal_error",
+}
+
+// classify returns the status code and the error code of err
+func classify(err error) (int, string) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		if len(reqErr.fields) > 0 {
+			return http.StatusUnprocessableEntity, "validation_failed"
+		}
+		return http.StatusBadRequest, "invalid_request"
+	}
+
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			return known.status, known.code
+		}
+	}
+
+	return http.StatusInternalServerError, "internal_error"
+}
+
+// codeForStatus returns the error code of an error reported with an explicit status code
+func codeForStatus(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// wantsProblem reports whether the client accepts problem details, other clients
+// keep getting the jsonResponse envelope
+func wantsProblem(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == problemContentType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newProblem builds the problem details of err for the request
+func newProblem(r *http.Request, err error, status int, code, detail string) problem {
+	p := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		p.Errors = reqErr.fields
+	}
+	p.AvailableAt = availableAgain(err)
+
+	return p
+}
+
+// availableAgain returns when the action refused with a cooldown error is available
+// again, nil for every other error
+func availableAgain(err error) *time.Time {
+	var cooldown *data.CooldownError
+	if errors.As(err, &cooldown) {
+		return &cooldown.AvailableAt
+	}
+
+	return nil
+}
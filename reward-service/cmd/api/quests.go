@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reward-service/data"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// questSources are the ledger sources whose points a points step can count
+var questSources = []string{data.SourceTask, data.SourceReferral, data.SourceAdmin, data.SourceCheckin}
+
+// questPayload is the body admins send to create a quest
+type questPayload struct {
+	Code        string             `json:"code" validate:"required,max=64,taskcode"`
+	Title       string             `json:"title" validate:"required,max=255"`
+	Description string             `json:"description,omitempty" validate:"max=2000"`
+	Bonus       int                `json:"bonus" validate:"required,min=1,max=100000"`
+	Ordered     bool               `json:"ordered"`
+	Requires    []string           `json:"requires,omitempty" validate:"max=20,dive,required,max=64"`
+	Steps       []questStepPayload `json:"steps" validate:"required,min=1,max=20,dive"`
+}
+
+// questStepPayload is one step of a questPayload
+type questStepPayload struct {
+	Event  string `json:"event" validate:"required,oneof=task referral checkin points"`
+	Target string `json:"target,omitempty" validate:"max=64"`
+	Count  int    `json:"count" validate:"required,min=1,max=1000000"`
+}
+
+// quest returns the quest described by the payload, the target of a step names the task
+// of a task step and the source of a points step
+func (p questPayload) quest() (data.Quest, error) {
+	var fields []fieldError
+	steps := make([]data.QuestStep, 0, len(p.Steps))
+	for i, step := range p.Steps {
+		field := fmt.Sprintf("steps[%d].target", i)
+		switch {
+		case step.Event == data.QuestEventTask && step.Target == "":
+			fields = append(fields, fieldError{Field: field, Code: "required", Message: "is required for a task step"})
+		case (step.Event == data.QuestEventReferral || step.Event == data.QuestEventCheckin) && step.Target != "":
+			fields = append(fields, fieldError{Field: field, Code: "invalid", Message: "can't be set for a " + step.Event + " step"})
+		case step.Event == data.QuestEventPoints && step.Target != "" && !slices.Contains(questSources, step.Target):
+			fields = append(fields, fieldError{Field: field, Code: "invalid", Message: "must be empty or one of " + strings.Join(questSources, ", ")})
+		}
+		steps = append(steps, data.QuestStep{Event: step.Event, Target: step.Target, Count: step.Count})
+	}
+	if len(fields) > 0 {
+		return data.Quest{}, invalidFields(fields...)
+	}
+
+	return data.Quest{
+		Code:        p.Code,
+		Title:       p.Title,
+		Description: p.Description,
+		Bonus:       p.Bonus,
+		Ordered:     p.Ordered,
+		Requires:    p.Requires,
+		Steps:       steps,
+	}, nil
+}
+
+// listQuests returns every quest
+func (app *Config) listQuests(w http.ResponseWriter, r *http.Request) {
+	quests, err := app.Repo.ListQuests(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch quests: %w", err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Fetched all quests",
+		Data:    quests,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// getQuest returns one quest by its code
+func (app *Config) getQuest(w http.ResponseWriter, r *http.Request) {
+	quest, err := app.Repo.GetQuest(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Fetched quest %s", quest.Code),
+		Data:    quest,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// createQuest adds a quest, admins only. The tasks of its steps and the quests it requires
+// must exist.
+func (app *Config) createQuest(w http.ResponseWriter, r *http.Request) {
+	var requestPayload questPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	quest, err := requestPayload.quest()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	for i, step := range quest.Steps {
+		if step.Event != data.QuestEventTask {
+			continue
+		}
+		_, err := app.Repo.GetTask(r.Context(), step.Target)
+		if errors.Is(err, data.ErrTaskNotFound) {
+			err = invalidFields(fieldError{Field: fmt.Sprintf("steps[%d].target", i), Code: "unknown_task", Message: "must be the code of a task"})
+		}
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	}
+	if _, err := app.Repo.InsertQuest(r.Context(), quest); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	stored, err := app.Repo.GetQuest(r.Context(), quest.Code)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Location", "/quests/"+quest.Code)
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created quest %s", quest.Code),
+		Data:    stored,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload, headers)
+}
+
+// deleteQuest removes a quest nobody completed or requires with the progress of the users
+// on it, admins only
+func (app *Config) deleteQuest(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	if err := app.Repo.DeleteQuest(r.Context(), code); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted quest %s", code),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// userQuests lists every quest with the progress of the user on its steps. Users see their
+// own quests, admins the quests of everyone.
+func (app *Config) userQuests(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	quests, err := app.Repo.UserQuests(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch quests of the user: %w", err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Fetched quests of user %d", id),
+		Data:    quests,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
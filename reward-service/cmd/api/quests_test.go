@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestQuests(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "quester@example.com", "pw")
+	token := ta.login(t, "quester@example.com", "pw")
+	ta.Settings.CheckinRewards = []int{10}
+	if _, err := ta.repo.Insert(context.Background(), data.User{Email: "questmaster@example.com", Password: "pw", Role: data.RoleAdmin, Active: 1}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := ta.login(t, "questmaster@example.com", "pw")
+	problems := http.Header{"Accept": {problemContentType}}
+
+	quest := map[string]any{
+		"code":  "starter",
+		"title": "Check in once",
+		"bonus": 30,
+		"steps": []map[string]any{{"event": "checkin", "count": 1}},
+	}
+	if rec := ta.do(t, http.MethodPost, "/quests", quest, token); rec.Code != http.StatusForbidden {
+		t.Errorf("quest created by a user = %d", rec.Code)
+	}
+	rec := ta.do(t, http.MethodPost, "/quests", quest, adminToken)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/quests/starter" {
+		t.Fatalf("create quest = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.doWithHeaders(t, http.MethodPost, "/quests", quest, adminToken, problems); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "duplicate_quest") {
+		t.Errorf("duplicate quest = %d: %s", rec.Code, rec.Body)
+	}
+
+	invalid := []struct {
+		name  string
+		steps []map[string]any
+		code  string
+	}{
+		{"no steps", []map[string]any{}, "steps"},
+		{"task step without a task", []map[string]any{{"event": "task", "count": 1}}, "steps[0].target"},
+		{"unknown task", []map[string]any{{"event": "task", "target": "nope", "count": 1}}, "unknown_task"},
+		{"target of a referral step", []map[string]any{{"event": "referral", "target": "x", "count": 1}}, "steps[0].target"},
+		{"unknown source", []map[string]any{{"event": "points", "target": "quest", "count": 1}}, "steps[0].target"},
+	}
+	for _, tt := range invalid {
+		body := map[string]any{"code": "invalid", "title": "Invalid", "bonus": 1, "steps": tt.steps}
+		rec := ta.doWithHeaders(t, http.MethodPost, "/quests", body, adminToken, problems)
+		if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), tt.code) {
+			t.Errorf("%s: create = %d: %s", tt.name, rec.Code, rec.Body)
+		}
+	}
+	locked := map[string]any{
+		"code":     "veteran",
+		"title":    "Earn 100 points",
+		"bonus":    50,
+		"requires": []string{"missing"},
+		"steps":    []map[string]any{{"event": "points", "count": 100}},
+	}
+	if rec := ta.doWithHeaders(t, http.MethodPost, "/quests", locked, adminToken, problems); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "unknown_prerequisite") {
+		t.Errorf("quest with an unknown prerequisite = %d: %s", rec.Code, rec.Body)
+	}
+	locked["requires"] = []string{"starter"}
+	if rec := ta.do(t, http.MethodPost, "/quests", locked, adminToken); rec.Code != http.StatusCreated {
+		t.Fatalf("create quest with a prerequisite = %d: %s", rec.Code, rec.Body)
+	}
+
+	base := "/users/" + strconv.Itoa(id)
+	if rec := ta.do(t, http.MethodPost, base+"/checkin", nil, token); rec.Code != http.StatusOK {
+		t.Fatalf("check-in = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 40 {
+		t.Errorf("score = %d, want the check-in and the bonus of starter", score)
+	}
+
+	rec = ta.do(t, http.MethodGet, base+"/quests", nil, token)
+	var resp struct {
+		Data []data.UserQuest `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 2 {
+		t.Fatalf("quests of the user = %d: %s", rec.Code, rec.Body)
+	}
+	if q := resp.Data[0]; q.Code != "starter" || q.CompletedAt == nil || q.Progress[0] != 1 {
+		t.Errorf("starter = %+v", q)
+	}
+	// the points of the check-in which completed starter came before veteran unlocked
+	if q := resp.Data[1]; q.Code != "veteran" || !q.Unlocked || q.CompletedAt != nil || q.Progress[0] != 0 {
+		t.Errorf("veteran = %+v", q)
+	}
+
+	// the arbitrary points of custom tasks don't count for points steps
+	if rec := ta.do(t, http.MethodPost, base+"/task/complete", map[string]int{"points": 1000}, adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("custom points = %d: %s", rec.Code, rec.Body)
+	}
+	quests, err := ta.repo.UserQuests(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := quests[1]; q.Progress[0] != 0 || q.CompletedAt != nil {
+		t.Errorf("veteran after custom points = %+v", q)
+	}
+	if score := ta.score(t, id); score != 1040 {
+		t.Errorf("score = %d, want 1040 without the bonus of veteran", score)
+	}
+
+	other := ta.register(t, "onlooker@example.com", "pw")
+	if rec := ta.do(t, http.MethodGet, "/users/"+strconv.Itoa(other)+"/quests", nil, token); rec.Code != http.StatusForbidden {
+		t.Errorf("quests of another user = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodGet, base+"/quests", nil, adminToken); rec.Code != http.StatusOK {
+		t.Errorf("quests of the user fetched by an admin = %d", rec.Code)
+	}
+
+	// starter was completed and veteran requires it, deleting it would unlock veteran for
+	// everyone and let the users earn its bonus again once it is added back
+	if rec := ta.doWithHeaders(t, http.MethodDelete, "/quests/starter", nil, adminToken, problems); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "quest_in_use") {
+		t.Errorf("delete of a completed quest = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodDelete, "/quests/veteran", nil, adminToken); rec.Code != http.StatusOK {
+		t.Errorf("delete quest = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.doWithHeaders(t, http.MethodGet, "/quests/veteran", nil, token, problems); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "quest_not_found") {
+		t.Errorf("deleted quest = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodDelete, "/quests/starter", nil, adminToken); rec.Code != http.StatusConflict {
+		t.Errorf("delete of a completed quest nothing requires = %d: %s", rec.Code, rec.Body)
+	}
+}
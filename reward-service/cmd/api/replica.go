@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reward-service/data"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// readYourWritesHeader asks for the reads of a request to go to the primary database
+const readYourWritesHeader = "X-Read-Your-Writes"
+
+// setupReplica opens the read replica pool and routes the read-only queries of the
+// repository to it. The replica isn't required to be up, while it is unhealthy the
+// reads go to the primary.
+func (app *Config) setupReplica() error {
+	router, ok := app.Repo.(data.ReplicaRouter)
+	if !ok {
+		app.Logger.Warn("The repository doesn't support read replicas, reading from the primary")
+		return nil
+	}
+
+	pool, err := data.Open(app.Settings.ReplicaDSN)
+	if err != nil {
+		return err
+	}
+	app.Settings.PoolOptions().Apply(pool)
+
+	if err := prometheus.Register(collectors.NewDBStatsCollector(pool, "users_replica")); err != nil {
+		pool.Close()
+		return err
+	}
+
+	app.Replica = pool
+	router.SetReplica(pool)
+	replicaHealthy.Set(1)
+	app.Workers.Go("replica-health", app.watchReplica(router))
+
+	return nil
+}
+
+// watchReplica returns the worker which checks the replica health at the configured
+// interval and logs every change of it
+func (app *Config) watchReplica(router data.ReplicaRouter) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(app.Settings.ReplicaCheck)
+		defer ticker.Stop()
+
+		healthy := true
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			err := router.CheckReplica(checkCtx)
+			cancel()
+
+			switch {
+			case err != nil && healthy:
+				app.Logger.Warn("Read replica is unhealthy, reading from the primary", "error", err)
+				replicaHealthy.Set(0)
+			case err == nil && !healthy:
+				app.Logger.Info("Read replica recovered")
+				replicaHealthy.Set(1)
+			}
+			healthy = err == nil
+		}
+	}
+}
+
+// readYourWrites sends the reads of writing requests, and of requests carrying the
+// X-Read-Your-Writes header, to the primary database so they see their own writes
+func readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if !safe || r.Header.Get(readYourWritesHeader) != "" {
+			r = r.WithContext(data.WithPrimary(r.Context()))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
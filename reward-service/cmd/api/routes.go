@@ -1,39 +1,108 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(app.tracingMiddleware)
+	mux.Use(middleware.RequestID)
+	mux.Use(app.requestLogger)
+	mux.Use(app.metricsMiddleware)
+	mux.Use(requestTimeout(app.Settings.RequestTimeout))
+	mux.Use(readYourWrites)
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedOrigins:   app.Settings.CORSOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", readYourWritesHeader, idempotencyKeyHeader},
+		ExposedHeaders:   []string{"Link", idempotentReplayedHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	mux.Get("/healthz", app.healthz)
+	mux.Get("/readyz", app.readyz)
+	mux.Handle("/metrics", promhttp.Handler())
+
 	mux.Group(func(r chi.Router) {
-		r.Use(app.authTokenMiddleware("some_secret_key")) //
+		r.Use(app.authTokenMiddleware(app.Settings.JWTSecret))
 
 		r.Get("/users/leaderboard", app.GetLeaderboard)
 		r.Get("/users/{id}/status", app.retrieveOne)
-		r.Post("/users/{id}/task/complete", app.completeTask)
-		r.Post("/users/{id}/task/telegramSign", app.completeTelegramSign)
-		r.Post("/users/{id}/task/XSign", app.completeXSign)
-		r.Post("/users/{id}/referrer", app.redeemReferrer)
+		r.Get("/users/{id}/transactions", app.pointHistory)
+		r.Get("/users/{id}/tasks", app.userTasks)
+		r.Put("/users/{id}/timezone", app.setTimezone)
+		r.Get("/users/{id}/quests", app.userQuests)
+		r.Get("/users/{id}/submissions", app.userSubmissions)
+		r.Post("/users/{id}/tasks/{code}/submissions", app.submitTask)
+		r.Get("/submissions/{id}", app.getSubmission)
+		r.Get("/submissions/{id}/image", app.submissionImage)
+		r.Get("/tasks", app.listTasks)
+		r.Get("/tasks/{code}", app.getTask)
+		r.Get("/quests", app.listQuests)
+		r.Get("/quests/{code}", app.getQuest)
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.requireAdmin)
+
+			r.Post("/tasks", app.createTask)
+			r.Put("/tasks/{code}", app.updateTask)
+			r.Delete("/tasks/{code}", app.deleteTask)
+			r.Get("/submissions", app.listSubmissions)
+			r.Post("/submissions/{id}/reject", app.rejectSubmission)
+			r.Post("/users/{id}/streak-freezes", app.grantStreakFreezes)
+			r.Post("/quests", app.createQuest)
+			r.Delete("/quests/{code}", app.deleteQuest)
+		})
+
+		// the routes changing points accept an Idempotency-Key
+		r.Group(func(r chi.Router) {
+			r.Use(app.idempotent)
+
+			r.With(app.requireAdmin).Post("/users/{id}/task/complete", app.completeTask)
+			r.Post("/users/{id}/tasks/{code}/complete", app.completeCatalogTask)
+			r.Post("/users/{id}/task/telegramSign", app.taskAlias(taskTelegram))
+			r.Post("/users/{id}/task/XSign", app.taskAlias(taskX))
+			r.Post("/users/{id}/referrer", app.redeemReferrer)
+			r.Post("/users/{id}/checkin", app.checkIn)
+			r.With(app.requireAdmin).Post("/submissions/{id}/approve", app.approveSubmission)
+		})
 	})
 
 	mux.Post("/authenticate", app.Authenticate)
 	mux.Post("/registrate", app.Registrate)
 
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, r, errors.New("route not found"), http.StatusNotFound)
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	})
+
 	return mux
 }
+
+// requestTimeout bounds the context of every request passing through it, so the
+// repository queries of a request are cancelled once its deadline has passed.
+// Mounting it again on a route with r.With sets a shorter deadline for that route.
+func requestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"reward-service/blob"
+	"reward-service/data"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// multipartMemory is how much of a multipart form is kept in memory, larger images are
+// buffered in temporary files
+const multipartMemory = 1 << 20
+
+// imageTypes are the images a submission may upload with the extension they are stored with
+var imageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var (
+	// errReviewRequired is returned when a task reviewed by moderators is completed directly
+	errReviewRequired = errors.New("the task is reviewed by a moderator, send a submission with a proof")
+	// errSubmissionNotAccepted is returned when a submission is sent for a task which is
+	// completed directly
+	errSubmissionNotAccepted = errors.New("the task is completed directly, it doesn't take submissions")
+)
+
+// submissionPayload is the proof a user sends with a submission, as JSON or as a field
+// of a multipart form which can also carry an image
+type submissionPayload struct {
+	Proof string `json:"proof" validate:"required,max=2000"`
+}
+
+// submissionView is a submission as the API shows it, with the URL of its image
+type submissionView struct {
+	data.Submission
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// viewOf returns the view of the submission
+func viewOf(submission data.Submission) submissionView {
+	view := submissionView{Submission: submission}
+	if submission.ImageKey != "" {
+		view.ImageURL = fmt.Sprintf("/submissions/%d/image", submission.ID)
+	}
+
+	return view
+}
+
+// submitTask queues a completion of a task reviewed by moderators with the proof of the
+// user. The body is JSON, or a multipart form when an image is uploaded with the proof.
+func (app *Config) submitTask(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	task, err := app.Repo.GetTask(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if task.Verifier != verifierManual {
+		app.errorJSON(w, r, errSubmissionNotAccepted)
+		return
+	}
+
+	payload, image, err := app.readSubmission(w, r)
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	submission := data.Submission{UserID: id, TaskCode: task.Code, Proof: payload.Proof}
+	if image != nil {
+		submission.ImageKey, err = app.storeImage(r.Context(), id, image)
+		if err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+	}
+
+	queued, err := app.Repo.SubmitTask(r.Context(), submission)
+	if err != nil {
+		if submission.ImageKey != "" {
+			if deleteErr := app.Blobs.Delete(context.WithoutCancel(r.Context()), submission.ImageKey); deleteErr != nil {
+				loggerFrom(r.Context()).Warn("Couldn't delete the image of a failed submission", "key", submission.ImageKey, "error", deleteErr)
+			}
+		}
+		app.errorJSON(w, r, fmt.Errorf("couldn't submit task %s: %w", task.Code, err))
+		return
+	}
+	taskSubmissions.WithLabelValues(data.SubmissionPending).Inc()
+
+	headers := http.Header{}
+	headers.Set("Location", fmt.Sprintf("/submissions/%d", queued.ID))
+	response := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("submission of task %s for user with id %d is waiting for review", task.Code, id),
+		Data:    viewOf(*queued),
+	}
+
+	app.writeJSON(w, http.StatusCreated, response, headers)
+}
+
+// readSubmission reads the proof of a submission and the uploaded image, if any
+func (app *Config) readSubmission(w http.ResponseWriter, r *http.Request) (submissionPayload, *multipart.FileHeader, error) {
+	var payload submissionPayload
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return payload, nil, app.readJSON(w, r, &payload)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(app.Settings.MaxImageSize)+maxRequestBytes)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return payload, nil, invalidFields(imageTooLarge(app.Settings.MaxImageSize))
+		}
+		return payload, nil, invalidRequest("body must be a valid multipart form: " + err.Error())
+	}
+
+	form := r.MultipartForm
+	var fields []fieldError
+	for name := range form.Value {
+		if name != "proof" {
+			fields = append(fields, fieldError{Field: name, Code: "unknown_field", Message: "is not a field of this request"})
+		}
+	}
+	for name := range form.File {
+		if name != "image" {
+			fields = append(fields, fieldError{Field: name, Code: "unknown_field", Message: "is not a field of this request"})
+		}
+	}
+	if len(form.Value["proof"]) > 1 || len(form.File["image"]) > 1 {
+		return payload, nil, invalidRequest("proof and image can be sent once")
+	}
+	if len(fields) > 0 {
+		return payload, nil, invalidFields(fields...)
+	}
+
+	payload.Proof = r.FormValue("proof")
+	if err := validatePayload(&payload); err != nil {
+		return payload, nil, err
+	}
+
+	if len(form.File["image"]) == 0 {
+		return payload, nil, nil
+	}
+	image := form.File["image"][0]
+	if image.Size > int64(app.Settings.MaxImageSize) {
+		return payload, nil, invalidFields(imageTooLarge(app.Settings.MaxImageSize))
+	}
+
+	return payload, image, nil
+}
+
+// imageTooLarge is the error of an image over the size limit
+func imageTooLarge(limit int) fieldError {
+	return fieldError{Field: "image", Code: "too_large", Message: fmt.Sprintf("must be at most %d bytes", limit)}
+}
+
+// storeImage puts the uploaded image in the blob store under a new key and returns it. The
+// type of the image is sniffed from its content, whatever the client claims.
+func (app *Config) storeImage(ctx context.Context, userID int, image *multipart.FileHeader) (string, error) {
+	f, err := image.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	ext, ok := imageTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", invalidFields(fieldError{Field: "image", Code: "unsupported_type", Message: "must be a PNG, JPEG, GIF or WebP image"})
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("submissions/%d/%s%s", userID, hex.EncodeToString(name), ext)
+	if err := app.Blobs.Put(ctx, key, f); err != nil {
+		return "", fmt.Errorf("storing the image: %w", err)
+	}
+
+	return key, nil
+}
+
+// submissionIDParam returns the submission id of the route
+func submissionIDParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, invalidRequest("submission id must be a number")
+	}
+
+	return id, nil
+}
+
+// submissionFilter reads the status query parameter of a submission listing
+func submissionFilter(r *http.Request, status string) (data.SubmissionFilter, error) {
+	if value := r.URL.Query().Get("status"); value != "" {
+		status = value
+	}
+
+	switch status {
+	case "", data.SubmissionPending, data.SubmissionApproved, data.SubmissionRejected:
+		return data.SubmissionFilter{Status: status}, nil
+	}
+
+	return data.SubmissionFilter{}, invalidFields(fieldError{Field: "status", Code: "invalid", Message: "must be one of pending, approved, rejected"})
+}
+
+// listSubmissions returns one page of the moderation queue, the pending submissions by
+// default, admins only
+func (app *Config) listSubmissions(w http.ResponseWriter, r *http.Request) {
+	filter, err := submissionFilter(r, data.SubmissionPending)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeSubmissions(w, r, filter, "Fetched task submissions")
+}
+
+// userSubmissions returns one page of the submissions of the user, newest first. Users see
+// their own submissions, admins the submissions of everyone.
+func (app *Config) userSubmissions(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	filter, err := submissionFilter(r, "")
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if _, err := app.Repo.GetOne(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	filter.UserID = id
+	app.writeSubmissions(w, r, filter, fmt.Sprintf("Fetched task submissions of user %d", id))
+}
+
+// writeSubmissions responds with the page of the submissions selected by the request
+func (app *Config) writeSubmissions(w http.ResponseWriter, r *http.Request, filter data.SubmissionFilter, message string) {
+	page, err := historyPage(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	submissions, err := app.Repo.ListSubmissions(r.Context(), filter, page)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch task submissions: %w", err))
+		return
+	}
+
+	list := struct {
+		Submissions []submissionView `json:"submissions"`
+		NextBefore  int64            `json:"next_before,omitempty"`
+	}{Submissions: make([]submissionView, 0, len(submissions))}
+	for _, submission := range submissions {
+		list.Submissions = append(list.Submissions, viewOf(submission))
+	}
+	if len(submissions) == page.Limit {
+		list.NextBefore = submissions[len(submissions)-1].ID
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    list,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// authorizedSubmission returns the submission of the route when the caller sent it or is an admin
+func (app *Config) authorizedSubmission(r *http.Request) (*data.Submission, error) {
+	id, err := submissionIDParam(r)
+	if err != nil {
+		return nil, err
+	}
+	submission, err := app.Repo.GetSubmission(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.authorizeUser(r.Context(), submission.UserID); err != nil {
+		return nil, err
+	}
+
+	return submission, nil
+}
+
+// getSubmission returns one submission to the user who sent it and to admins
+func (app *Config) getSubmission(w http.ResponseWriter, r *http.Request) {
+	submission, err := app.authorizedSubmission(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Fetched submission %d", submission.ID),
+		Data:    viewOf(*submission),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// submissionImage serves the image uploaded with a submission to the user who sent it and
+// to admins
+func (app *Config) submissionImage(w http.ResponseWriter, r *http.Request) {
+	submission, err := app.authorizedSubmission(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if submission.ImageKey == "" {
+		app.errorJSON(w, r, errors.New("the submission has no image"), http.StatusNotFound)
+		return
+	}
+
+	image, err := app.Blobs.Open(r.Context(), submission.ImageKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		app.errorJSON(w, r, errors.New("the image of the submission is gone"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't open the image: %w", err))
+		return
+	}
+	defer image.Close()
+
+	contentType := "application/octet-stream"
+	for imageType, ext := range imageTypes {
+		if path.Ext(submission.ImageKey) == ext {
+			contentType = imageType
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, image); err != nil {
+		loggerFrom(r.Context()).Warn("Couldn't send the image of a submission", "submission", submission.ID, "error", err)
+	}
+}
+
+// approveSubmission completes the task of a pending submission and awards its points like
+// any other completion, admins only
+func (app *Config) approveSubmission(w http.ResponseWriter, r *http.Request) {
+	id, err := submissionIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	change := data.PointChange{
+		Source:  data.SourceTask,
+		Reason:  "submission approved",
+		ActorID: callerID(r.Context()),
+	}
+	submission, err := app.Repo.ApproveSubmission(r.Context(), id, change)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't approve submission %d: %w", id, err))
+		return
+	}
+	pointsAwarded.WithLabelValues(submission.TaskCode).Add(float64(submission.Points))
+	taskSubmissions.WithLabelValues(data.SubmissionApproved).Inc()
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("approved submission %d, added points %d to user with id %d", id, submission.Points, submission.UserID),
+		Data:    viewOf(*submission),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// rejectSubmission closes a pending submission with the reason shown to the user, admins only
+func (app *Config) rejectSubmission(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Reason string `json:"reason" validate:"required,max=1000"`
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	id, err := submissionIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	submission, err := app.Repo.RejectSubmission(r.Context(), id, callerID(r.Context()), requestPayload.Reason)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't reject submission %d: %w", id, err))
+		return
+	}
+	taskSubmissions.WithLabelValues(data.SubmissionRejected).Inc()
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("rejected submission %d", id),
+		Data:    viewOf(*submission),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pngHeader is enough of a PNG file for its type to be sniffed
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// submitForm sends a submission as a multipart form with the proof and an image
+func (ta *testApp) submitForm(t *testing.T, path, proof string, image []byte, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("proof", proof); err != nil {
+		t.Fatal(err)
+	}
+	if image != nil {
+		part, err := form.CreateFormFile("image", "proof.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(image)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+
+	rec := httptest.NewRecorder()
+	ta.handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestTaskSubmissions(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.register(t, "reviewer@example.com", "pw")
+	token := ta.login(t, "reviewer@example.com", "pw")
+	if _, err := ta.repo.Insert(context.Background(), data.User{Email: "moderator@example.com", Password: "pw", Role: data.RoleAdmin, Active: 1}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := ta.login(t, "moderator@example.com", "pw")
+
+	review := map[string]any{"code": "review", "title": "Write a review", "points": 40, "verifier": "manual"}
+	if rec := ta.do(t, http.MethodPost, "/tasks", review, adminToken); rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+	}
+
+	base := "/users/" + strconv.Itoa(id)
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/review/complete", nil, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("direct completion of a reviewed task = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/x/submissions", map[string]string{"proof": "p"}, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("submission of a direct task = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.submitForm(t, base+"/tasks/review/submissions", "p", []byte("not an image"), token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("submission with a text file = %d: %s", rec.Code, rec.Body)
+	}
+	ta.Settings.MaxImageSize = 8
+	if rec := ta.submitForm(t, base+"/tasks/review/submissions", "p", pngHeader, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("submission with a large image = %d: %s", rec.Code, rec.Body)
+	}
+	ta.Settings.MaxImageSize = 1 << 20
+
+	rec := ta.submitForm(t, base+"/tasks/review/submissions", "https://example.com/review", pngHeader, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("submission = %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		Data submissionView `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	submission := created.Data
+	location := "/submissions/" + strconv.FormatInt(submission.ID, 10)
+	if rec.Header().Get("Location") != location || submission.Status != data.SubmissionPending || submission.ImageURL != location+"/image" {
+		t.Errorf("submission = %+v at %q", submission, rec.Header().Get("Location"))
+	}
+	if rec := ta.do(t, http.MethodPost, base+"/tasks/review/submissions", map[string]string{"proof": "again"}, token); rec.Code != http.StatusConflict {
+		t.Errorf("second pending submission = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = ta.do(t, http.MethodGet, location+"/image", nil, token)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rec.Body.Bytes(), pngHeader) {
+		t.Errorf("image = %d %q: %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	other := ta.register(t, "curious@example.com", "pw")
+	otherToken := ta.login(t, "curious@example.com", "pw")
+	if rec := ta.do(t, http.MethodGet, location, nil, otherToken); rec.Code != http.StatusForbidden {
+		t.Errorf("submission of another user = %d", rec.Code)
+	}
+
+	// the moderation queue is for admins, pending submissions by default
+	if rec := ta.do(t, http.MethodGet, "/submissions", nil, token); rec.Code != http.StatusForbidden {
+		t.Errorf("queue for a user = %d", rec.Code)
+	}
+	var queue struct {
+		Data struct {
+			Submissions []submissionView `json:"submissions"`
+		} `json:"data"`
+	}
+	rec = ta.do(t, http.MethodGet, "/submissions", nil, adminToken)
+	if err := json.Unmarshal(rec.Body.Bytes(), &queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Data.Submissions) != 1 || queue.Data.Submissions[0].ID != submission.ID {
+		t.Errorf("queue = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodGet, "/submissions?status=lost", nil, adminToken); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("queue with an unknown status = %d", rec.Code)
+	}
+
+	if rec := ta.do(t, http.MethodPost, location+"/approve", nil, token); rec.Code != http.StatusForbidden {
+		t.Errorf("approval by a user = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodPost, location+"/approve", nil, adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("approval = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := ta.do(t, http.MethodPost, location+"/reject", map[string]string{"reason": "late"}, adminToken); rec.Code != http.StatusConflict {
+		t.Errorf("rejection of an approved submission = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 40 {
+		t.Errorf("score after the approval = %d, want 40", score)
+	}
+
+	// a rejection tells the user why and awards nothing
+	video := map[string]any{"code": "video", "title": "Make a video", "points": 100, "verifier": "manual"}
+	if rec := ta.do(t, http.MethodPost, "/tasks", video, adminToken); rec.Code != http.StatusCreated {
+		t.Fatalf("create = %d: %s", rec.Code, rec.Body)
+	}
+	otherBase := "/users/" + strconv.Itoa(other)
+	if rec := ta.do(t, http.MethodPost, otherBase+"/tasks/video/submissions", map[string]string{"proof": "a video"}, otherToken); rec.Code != http.StatusCreated {
+		t.Fatalf("submission = %d: %s", rec.Code, rec.Body)
+	}
+	videoLocation := "/submissions/" + strconv.FormatInt(submission.ID+1, 10)
+	if rec := ta.do(t, http.MethodPost, videoLocation+"/reject", map[string]string{}, adminToken); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("rejection without a reason = %d", rec.Code)
+	}
+	if rec := ta.do(t, http.MethodPost, videoLocation+"/reject", map[string]string{"reason": "the video is private"}, adminToken); rec.Code != http.StatusOK {
+		t.Fatalf("rejection = %d: %s", rec.Code, rec.Body)
+	}
+	rec = ta.do(t, http.MethodGet, otherBase+"/submissions", nil, otherToken)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "the video is private") {
+		t.Errorf("submissions of the user = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, other); score != 0 {
+		t.Errorf("score after the rejection = %d, want 0", score)
+	}
+}
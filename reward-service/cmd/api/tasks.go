@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reward-service/data"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// taskPayload is the body admins send to create or replace a task
+type taskPayload struct {
+	Title           string     `json:"title" validate:"required,max=255"`
+	Description     string     `json:"description,omitempty" validate:"max=2000"`
+	Points          int        `json:"points" validate:"required,min=1,max=100000"`
+	StartsAt        *time.Time `json:"starts_at,omitempty"`
+	EndsAt          *time.Time `json:"ends_at,omitempty"`
+	Repeatable      bool       `json:"repeatable"`
+	MaxCompletions  int        `json:"max_completions" validate:"min=0,max=100000"`
+	CooldownSeconds int        `json:"cooldown_seconds,omitempty" validate:"min=0,max=31536000"`
+	DailyLimit      int        `json:"daily_limit,omitempty" validate:"min=0,max=100000"`
+	WeeklyLimit     int        `json:"weekly_limit,omitempty" validate:"min=0,max=100000"`
+	Verifier        string     `json:"verifier,omitempty" validate:"omitempty,oneof=telegram x manual"`
+}
+
+// task returns the task with the code described by the payload
+func (p taskPayload) task(code string) (data.Task, error) {
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return data.Task{}, invalidFields(fieldError{Field: "ends_at", Code: "invalid", Message: "must be after starts_at"})
+	}
+	if !p.Repeatable {
+		// a task completed once has nothing to limit
+		limits := []struct {
+			field string
+			value int
+		}{{"cooldown_seconds", p.CooldownSeconds}, {"daily_limit", p.DailyLimit}, {"weekly_limit", p.WeeklyLimit}}
+		var fields []fieldError
+		for _, limit := range limits {
+			if limit.value > 0 {
+				fields = append(fields, fieldError{Field: limit.field, Code: "invalid", Message: "needs a repeatable task"})
+			}
+		}
+		if len(fields) > 0 {
+			return data.Task{}, invalidFields(fields...)
+		}
+	}
+
+	return data.Task{
+		Code:            code,
+		Title:           p.Title,
+		Description:     p.Description,
+		Points:          p.Points,
+		StartsAt:        p.StartsAt,
+		EndsAt:          p.EndsAt,
+		Repeatable:      p.Repeatable,
+		MaxCompletions:  p.MaxCompletions,
+		CooldownSeconds: p.CooldownSeconds,
+		DailyLimit:      p.DailyLimit,
+		WeeklyLimit:     p.WeeklyLimit,
+		Verifier:        p.Verifier,
+	}, nil
+}
+
+// listTasks returns the whole task catalog
+func (app *Config) listTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := app.Repo.ListTasks(r.Context())
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch tasks: %w", err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Fetched all tasks",
+		Data:    tasks,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// getTask returns one task of the catalog by its code
+func (app *Config) getTask(w http.ResponseWriter, r *http.Request) {
+	task, err := app.Repo.GetTask(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Fetched task %s", task.Code),
+		Data:    task,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// createTask adds a task to the catalog, admins only
+func (app *Config) createTask(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Code string `json:"code" validate:"required,max=64,taskcode"`
+		taskPayload
+	}
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	task, err := requestPayload.task(requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if _, err := app.Repo.InsertTask(r.Context(), task); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeTask(w, r, task.Code, http.StatusCreated, fmt.Sprintf("Created task %s", task.Code))
+}
+
+// updateTask replaces a task of the catalog, admins only
+func (app *Config) updateTask(w http.ResponseWriter, r *http.Request) {
+	var requestPayload taskPayload
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	task, err := requestPayload.task(chi.URLParam(r, "code"))
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.Repo.UpdateTask(r.Context(), task); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	app.writeTask(w, r, task.Code, http.StatusOK, fmt.Sprintf("Updated task %s", task.Code))
+}
+
+// writeTask responds with the stored task after it was written
+func (app *Config) writeTask(w http.ResponseWriter, r *http.Request, code string, status int, message string) {
+	task, err := app.Repo.GetTask(r.Context(), code)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	if status == http.StatusCreated {
+		headers.Set("Location", "/tasks/"+code)
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    task,
+	}
+
+	app.writeJSON(w, status, payload, headers)
+}
+
+// deleteTask removes a task nobody completed or submitted from the catalog, admins only
+func (app *Config) deleteTask(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	if err := app.Repo.DeleteTask(r.Context(), code); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted task %s", code),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// completeCatalogTask completes the task of the catalog named by the route and awards its points
+func (app *Config) completeCatalogTask(w http.ResponseWriter, r *http.Request) {
+	app.completeTaskCode(w, r, chi.URLParam(r, "code"))
+}
+
+// taskAlias returns the handler of a route which completes one fixed task of the catalog,
+// it keeps the routes which existed before the catalog working
+func (app *Config) taskAlias(code string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.completeTaskCode(w, r, code)
+	}
+}
+
+// completeTaskCode records the completion of the task with the code by the user of the route
+// and awards its points, a task which isn't repeatable is completed once. Users complete
+// their own tasks, admins anyone's. A task with a verifier needs the account of the user
+// to check, while the check is undecided the completion is pending and answered with 202.
+func (app *Config) completeTaskCode(w http.ResponseWriter, r *http.Request, code string) {
+	var requestPayload struct {
+		Account string `json:"account,omitempty" validate:"max=255"`
+	}
+	err := app.readOptionalJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	task, err := app.Repo.GetTask(r.Context(), code)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if task.Verifier == verifierManual {
+		app.errorJSON(w, r, errReviewRequired)
+		return
+	}
+	var pending *data.TaskCompletion
+	if _, ok := app.Verifiers[task.Verifier]; ok {
+		if requestPayload.Account == "" {
+			app.errorJSON(w, r, invalidFields(fieldError{Field: "account", Code: "required", Message: "is required to verify the task"}))
+			return
+		}
+		// the pending completion binds the account to the user before it is checked, and
+		// keeps it on the completion once verified
+		pending, err = app.Repo.SetTaskPending(r.Context(), id, code, requestPayload.Account)
+		if err != nil {
+			app.errorJSON(w, r, fmt.Errorf("couldn't complete task %s: %w", code, err))
+			return
+		}
+	}
+
+	switch app.verify(r.Context(), task, requestPayload.Account) {
+	case NotVerified:
+		if err := app.Repo.CancelPendingTask(r.Context(), id, code); err != nil {
+			app.errorJSON(w, r, err)
+			return
+		}
+		app.errorJSON(w, r, errTaskNotVerified)
+		return
+	case VerificationPending:
+		payload := jsonResponse{
+			Error:   false,
+			Message: fmt.Sprintf("verification of task %s for user with id %d is pending", code, id),
+			Data:    pending,
+		}
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
+	change := data.PointChange{
+		Source:  data.SourceTask,
+		Reason:  "task completed",
+		ActorID: callerID(r.Context()),
+	}
+	completion, err := app.Repo.CompleteTask(r.Context(), id, code, change)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't complete task %s: %w", code, err))
+		return
+	}
+	pointsAwarded.WithLabelValues(completion.TaskCode).Add(float64(completion.Points))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("completed task %s for user with id %d, added points %d", code, id, completion.Points),
+		Data:    completion,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// readOptionalJSON is readJSON for the requests whose body may be left out
+func (app *Config) readOptionalJSON(w http.ResponseWriter, r *http.Request, data any) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		return invalidRequest("couldn't read the request body: " + err.Error())
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return validatePayload(data)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return app.readJSON(w, r, data)
+}
+
+// userTasks lists the tasks the user completed and the tasks available to them now. Users
+// see their own tasks, admins the tasks of everyone.
+func (app *Config) userTasks(w http.ResponseWriter, r *http.Request) {
+	id, err := userIDParam(r)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if err := app.authorizeUser(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if _, err := app.Repo.GetOne(r.Context(), id); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	tasks, err := app.Repo.UserTasks(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, r, fmt.Errorf("couldn't fetch tasks of the user: %w", err))
+		return
+	}
+
+	overview := struct {
+		Completed []data.UserTask `json:"completed"`
+		Available []data.UserTask `json:"available"`
+	}{Completed: []data.UserTask{}, Available: []data.UserTask{}}
+	now := time.Now()
+	for _, task := range tasks {
+		if task.Completions > 0 {
+			overview.Completed = append(overview.Completed, task)
+		}
+		if task.Available(now) {
+			overview.Available = append(overview.Available, task)
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Fetched tasks of user %d", id),
+		Data:    overview,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validate checks the `validate` struct tags of the request payloads, see readJSON
+var validate = newValidator()
+
+// serverFields are only ever set by the service, a payload trying to set them is rejected
+var serverFields = map[string]bool{
+	"id":         true,
+	"score":      true,
+	"active":     true,
+	"role":       true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// taskCode is the form of the task codes, they appear in the task routes
+var taskCode = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// newValidator returns a validator naming the fields by their JSON names
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	_ = v.RegisterValidation("taskcode", func(fl validator.FieldLevel) bool {
+		return taskCode.MatchString(fl.Field().String())
+	})
+	// maxbytes limits the length of a string in bytes, bcrypt hashes at most 72 bytes of a
+	// password while max counts characters
+	_ = v.RegisterValidation("maxbytes", func(fl validator.FieldLevel) bool {
+		limit, err := strconv.Atoi(fl.Param())
+		return err == nil && len(fl.Field().String()) <= limit
+	})
+
+	return v
+}
+
+// validatePayload checks payload against its validate tags and returns the rejected fields
+func validatePayload(payload any) error {
+	err := validate.Struct(payload)
+
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	fields := make([]fieldError, 0, len(invalid))
+	for _, fe := range invalid {
+		fields = append(fields, describeField(fe))
+	}
+
+	return invalidFields(fields...)
+}
+
+// describeField turns the failed rule of a field into its error code and message
+func describeField(fe validator.FieldError) fieldError {
+	field := fieldError{Field: fe.Field()}
+	numeric := fe.Kind() >= reflect.Int && fe.Kind() <= reflect.Float64
+
+	switch {
+	case fe.Tag() == "required":
+		field.Code, field.Message = "required", "is required"
+	case fe.Tag() == "email":
+		field.Code, field.Message = "invalid_email", "must be a valid email address"
+	case numeric && (fe.Tag() == "min" || fe.Tag() == "gte"):
+		field.Code, field.Message = "out_of_range", "must be at least "+fe.Param()
+	case numeric && (fe.Tag() == "max" || fe.Tag() == "lte"):
+		field.Code, field.Message = "out_of_range", "must be at most "+fe.Param()
+	case fe.Kind() == reflect.Slice && fe.Tag() == "min":
+		field.Code, field.Message = "too_short", "must have at least "+fe.Param()+" items"
+	case fe.Kind() == reflect.Slice && fe.Tag() == "max":
+		field.Code, field.Message = "too_long", "must have at most "+fe.Param()+" items"
+	case fe.Tag() == "min":
+		field.Code, field.Message = "too_short", "must be at least "+fe.Param()+" characters long"
+	case fe.Tag() == "max":
+		field.Code, field.Message = "too_long", "must be at most "+fe.Param()+" characters long"
+	case fe.Tag() == "maxbytes":
+		field.Code, field.Message = "too_long", "must be at most "+fe.Param()+" bytes long"
+	case fe.Tag() == "oneof":
+		field.Code, field.Message = "invalid", "must be one of "+strings.ReplaceAll(fe.Param(), " ", ", ")
+	case fe.Tag() == "taskcode":
+		field.Code, field.Message = "invalid", "must contain only letters, digits, - and _"
+	case fe.Tag() == "timezone":
+		field.Code, field.Message = "invalid", "must be an IANA time zone like Europe/Moscow"
+	default:
+		field.Code, field.Message = "invalid", fmt.Sprintf("fails the %s rule", fe.Tag())
+	}
+
+	return field
+}
+
+// unknownField turns the error of a JSON decoder disallowing unknown fields into the rejected field
+func unknownField(err error) (fieldError, bool) {
+	if err == nil {
+		return fieldError{}, false
+	}
+
+	name, found := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !found {
+		return fieldError{}, false
+	}
+
+	name = strings.Trim(name, `"`)
+	if serverFields[name] {
+		return fieldError{Field: name, Code: "read_only", Message: "is set by the service and can't be sent"}, true
+	}
+
+	return fieldError{Field: name, Code: "unknown_field", Message: "is not a field of this request"}, true
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reward-service/data"
+	"time"
+)
+
+// names of the verifiers a task of the catalog may ask for
+const (
+	verifierTelegram = "telegram"
+	verifierX        = "x"
+	// verifierManual tasks are completed by the approval of a submission, see submitTask
+	verifierManual = "manual"
+)
+
+// pendingBatch is how many pending completions the verification worker retries at once
+const pendingBatch = 100
+
+// maxFollowingPages bounds the pages of followings an X verification reads. X returns the
+// most recent follows first, so a user who just followed is found on the first page.
+const maxFollowingPages = 5
+
+// errTaskNotVerified is returned when the verifier of a task says the user didn't do it
+var errTaskNotVerified = errors.New("the task could not be verified, complete it and try again")
+
+// Verification is the outcome of a task verification
+type Verification int
+
+const (
+	Verified Verification = iota
+	NotVerified
+	// VerificationPending means the verifier couldn't decide now, the completion is
+	// retried later
+	VerificationPending
+)
+
+func (v Verification) String() string {
+	switch v {
+	case Verified:
+		return "verified"
+	case NotVerified:
+		return "not_verified"
+	default:
+		return "pending"
+	}
+}
+
+// TaskVerifier checks on another platform that a user did what a task asks for, before
+// the points of the task are awarded. Account is the user's account on that platform.
+// An error means the verification couldn't be decided, it is treated as pending.
+type TaskVerifier interface {
+	Verify(ctx context.Context, account string) (Verification, error)
+}
+
+// TelegramVerifier checks with the Bot API getChatMember method that a user is a member
+// of a channel, the bot has to be an administrator of it. The bot token is part of the
+// URL, Client must not record it, see telegramClient.
+type TelegramVerifier struct {
+	Client  *http.Client
+	BaseURL string
+	Token   string
+	ChatID  string
+}
+
+// Verify checks that the Telegram user id account is a member of the channel
+func (v *TelegramVerifier) Verify(ctx context.Context, account string) (Verification, error) {
+	query := url.Values{"chat_id": {v.ChatID}, "user_id": {account}}
+	endpoint := fmt.Sprintf("%s/bot%s/getChatMember?%s", v.BaseURL, v.Token, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return VerificationPending, err
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return VerificationPending, fmt.Errorf("calling the Telegram Bot API: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return VerificationPending, nil
+	}
+
+	var body struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      struct {
+			Status   string `json:"status"`
+			IsMember bool   `json:"is_member"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return VerificationPending, fmt.Errorf("decoding the getChatMember response: %w", err)
+	}
+	if !body.OK {
+		// a user the chat doesn't know is a bad request, any other error is the bot's
+		if resp.StatusCode == http.StatusBadRequest {
+			return NotVerified, nil
+		}
+		return VerificationPending, fmt.Errorf("telegram bot API: %d %s", resp.StatusCode, body.Description)
+	}
+
+	switch body.Result.Status {
+	case "creator", "administrator", "member":
+		return Verified, nil
+	case "restricted":
+		if body.Result.IsMember {
+			return Verified, nil
+		}
+	}
+
+	return NotVerified, nil
+}
+
+// XVerifier checks with the X API that a user follows an account
+type XVerifier struct {
+	Client       *http.Client
+	BaseURL      string
+	BearerToken  string
+	TargetUserID string
+}
+
+// Verify checks that the X user id account follows the target account
+func (v *XVerifier) Verify(ctx context.Context, account string) (Verification, error) {
+	next := ""
+	for page := 0; page < maxFollowingPages; page++ {
+		query := url.Values{"max_results": {"1000"}}
+		if next != "" {
+			query.Set("pagination_token", next)
+		}
+		endpoint := fmt.Sprintf("%s/2/users/%s/following?%s", v.BaseURL, url.PathEscape(account), query.Encode())
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return VerificationPending, err
+		}
+		req.Header.Set("Authorization", "Bearer "+v.BearerToken)
+
+		resp, err := v.Client.Do(req)
+		if err != nil {
+			return VerificationPending, fmt.Errorf("calling the X API: %w", withoutURL(err))
+		}
+
+		var body struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			Meta struct {
+				NextToken string `json:"next_token"`
+			} `json:"meta"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+			return VerificationPending, nil
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return VerificationPending, fmt.Errorf("x API rejected the bearer token: %d", resp.StatusCode)
+		case resp.StatusCode != http.StatusOK:
+			return NotVerified, nil
+		case err != nil:
+			return VerificationPending, fmt.Errorf("decoding the following response: %w", err)
+		}
+
+		for _, followed := range body.Data {
+			if followed.ID == v.TargetUserID {
+				return Verified, nil
+			}
+		}
+		if body.Meta.NextToken == "" {
+			return NotVerified, nil
+		}
+		next = body.Meta.NextToken
+	}
+
+	return NotVerified, nil
+}
+
+// withoutURL drops the URL from the error of an HTTP client, it carries the bot token
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+
+	return err
+}
+
+// telegramClient returns the client of the Telegram Bot API, its spans leave out the URL
+// which carries the bot token
+func telegramClient(base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: redactedTransport(base, "telegram.getChatMember")}
+}
+
+// setupVerifiers creates the verifiers which are configured and starts the worker retrying
+// the pending verifications. Tasks whose verifier isn't configured are trusted.
+func (app *Config) setupVerifiers() {
+	app.Verifiers = make(map[string]TaskVerifier)
+
+	if app.Settings.TelegramToken != "" {
+		app.Verifiers[verifierTelegram] = &TelegramVerifier{
+			Client:  telegramClient(http.DefaultTransport),
+			BaseURL: app.Settings.TelegramAPIURL,
+			Token:   app.Settings.TelegramToken,
+			ChatID:  app.Settings.TelegramChatID,
+		}
+	} else {
+		app.Logger.Warn("TELEGRAM_BOT_TOKEN isn't set, Telegram tasks are completed without verification")
+	}
+
+	if app.Settings.XBearerToken != "" {
+		app.Verifiers[verifierX] = &XVerifier{
+			Client:       app.Client,
+			BaseURL:      app.Settings.XAPIURL,
+			BearerToken:  app.Settings.XBearerToken,
+			TargetUserID: app.Settings.XTargetUserID,
+		}
+	} else {
+		app.Logger.Warn("X_BEARER_TOKEN isn't set, X tasks are completed without verification")
+	}
+
+	app.Workers.Go("task-verification", app.retryVerifications)
+}
+
+// verify runs the verifier of the task for the account, a task without a configured
+// verifier is verified
+func (app *Config) verify(ctx context.Context, task *data.Task, account string) Verification {
+	verifier, ok := app.Verifiers[task.Verifier]
+	if !ok {
+		return Verified
+	}
+
+	result, err := verifier.Verify(ctx, account)
+	if err != nil {
+		loggerFrom(ctx).Warn("Task verification failed", "task", task.Code, "verifier", task.Verifier, "error", err)
+		result = VerificationPending
+	}
+	taskVerifications.WithLabelValues(task.Verifier, result.String()).Inc()
+
+	return result
+}
+
+// retryVerifications is the worker which verifies the pending completions again at the
+// configured interval, awarding the points of the verified ones
+func (app *Config) retryVerifications(ctx context.Context) {
+	ticker := time.NewTicker(app.Settings.VerifyRetry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pending, err := app.Repo.PendingTasks(ctx, pendingBatch)
+		if err != nil {
+			app.Logger.Warn("Couldn't fetch pending task verifications", "error", err)
+			continue
+		}
+
+		for _, completion := range pending {
+			if err := app.retryVerification(ctx, completion); err != nil {
+				app.Logger.Warn("Couldn't retry task verification", "user", completion.UserID, "task", completion.TaskCode, "error", err)
+			}
+		}
+	}
+}
+
+// retryVerification verifies one pending completion again
+func (app *Config) retryVerification(ctx context.Context, completion data.TaskCompletion) error {
+	task, err := app.Repo.GetTask(data.WithPrimary(ctx), completion.TaskCode)
+	if err != nil {
+		return err
+	}
+
+	switch app.verify(ctx, task, completion.Account) {
+	case Verified:
+		change := data.PointChange{Source: data.SourceTask, Reason: "task verified"}
+		completed, err := app.Repo.CompleteTask(ctx, completion.UserID, task.Code, change)
+		if errors.Is(err, data.ErrTaskCompleted) || errors.Is(err, data.ErrTaskInactive) {
+			// the task ended or was completed in the meantime
+			return app.Repo.CancelPendingTask(ctx, completion.UserID, task.Code)
+		}
+		if errors.Is(err, data.ErrTaskCooldown) {
+			// the completion stays pending until the task is available again
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pointsAwarded.WithLabelValues(completed.TaskCode).Add(float64(completed.Points))
+	case NotVerified:
+		return app.Repo.CancelPendingTask(ctx, completion.UserID, task.Code)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	fakeBotToken    = "123:fake-token"
+	fakeChatID      = "@rewards"
+	fakeBearerToken = "fake-bearer"
+	fakeTargetID    = "42"
+)
+
+// fakeTelegram is an offline Telegram Bot API answering getChatMember from members, which
+// maps a user id to its chat member status. A user missing from it is unknown to the chat,
+// the status "busy" is answered with 429.
+type fakeTelegram struct {
+	mu      sync.Mutex
+	members map[string]string
+}
+
+func (f *fakeTelegram) setStatus(user, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members[user] = status
+}
+
+func (f *fakeTelegram) start(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/bot"+fakeBotToken+"/getChatMember" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "Unauthorized"})
+			return
+		}
+		if r.URL.Query().Get("chat_id") != fakeChatID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "Bad Request: chat not found"})
+			return
+		}
+
+		f.mu.Lock()
+		status, ok := f.members[r.URL.Query().Get("user_id")]
+		f.mu.Unlock()
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "Bad Request: user not found"})
+		case status == "busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "Too Many Requests"})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": map[string]any{"status": status}})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// fakeX is an offline X API answering the following lookup from following, which maps a
+// user id to the ids they follow, newest first. It returns pages of two ids.
+func fakeX(t *testing.T, following map[string][]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer "+fakeBearerToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		user, found := strings.CutPrefix(r.URL.Path, "/2/users/")
+		user, found2 := strings.CutSuffix(user, "/following")
+		ids, known := following[user]
+		if !found || !found2 || !known {
+			json.NewEncoder(w).Encode(map[string]any{"errors": []any{map[string]any{"title": "Not Found Error"}}})
+			return
+		}
+		if user == "limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		start, _ := strconv.Atoi(r.URL.Query().Get("pagination_token"))
+		end := min(start+2, len(ids))
+		data := []map[string]string{}
+		for _, id := range ids[start:end] {
+			data = append(data, map[string]string{"id": id})
+		}
+		meta := map[string]any{"result_count": len(data)}
+		if end < len(ids) {
+			meta["next_token"] = strconv.Itoa(end)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data, "meta": meta})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestTelegramVerifier(t *testing.T) {
+	fake := &fakeTelegram{members: map[string]string{"1": "member", "2": "left", "3": "busy", "4": "creator"}}
+	srv := fake.start(t)
+	verifier := &TelegramVerifier{Client: srv.Client(), BaseURL: srv.URL, Token: fakeBotToken, ChatID: fakeChatID}
+
+	tests := []struct {
+		account string
+		want    Verification
+	}{
+		{"1", Verified},
+		{"4", Verified},
+		{"2", NotVerified},
+		{"99", NotVerified},
+		{"3", VerificationPending},
+	}
+	for _, tt := range tests {
+		got, err := verifier.Verify(context.Background(), tt.account)
+		if err != nil || got != tt.want {
+			t.Errorf("Verify(%s) = %v, %v, want %v", tt.account, got, err, tt.want)
+		}
+	}
+
+	wrongToken := &TelegramVerifier{Client: srv.Client(), BaseURL: srv.URL, Token: "wrong", ChatID: fakeChatID}
+	if got, err := wrongToken.Verify(context.Background(), "1"); got != VerificationPending || err == nil {
+		t.Errorf("Verify with a wrong token = %v, %v, want pending with an error", got, err)
+	}
+
+	srv.Close()
+	_, err := verifier.Verify(context.Background(), "1")
+	if err == nil || strings.Contains(err.Error(), fakeBotToken) {
+		t.Errorf("error of an unreachable API = %v, want one without the token", err)
+	}
+}
+
+func TestTelegramVerifierSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	fake := &fakeTelegram{members: map[string]string{"1": "member"}}
+	srv := fake.start(t)
+	verifier := &TelegramVerifier{Client: telegramClient(http.DefaultTransport), BaseURL: srv.URL, Token: fakeBotToken, ChatID: fakeChatID}
+	if got, err := verifier.Verify(context.Background(), "1"); err != nil || got != Verified {
+		t.Fatalf("Verify = %v, %v", got, err)
+	}
+	srv.Close()
+	if _, err := verifier.Verify(context.Background(), "1"); err == nil {
+		t.Fatal("Verify of an unreachable API succeeded")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	for _, span := range spans {
+		recorded := span.Name() + " " + span.Status().Description
+		for _, attr := range span.Attributes() {
+			recorded += " " + string(attr.Key) + "=" + attr.Value.Emit()
+		}
+		for _, event := range span.Events() {
+			for _, attr := range event.Attributes {
+				recorded += " " + string(attr.Key) + "=" + attr.Value.Emit()
+			}
+		}
+		if strings.Contains(recorded, fakeBotToken) {
+			t.Errorf("span records the bot token: %s", recorded)
+		}
+	}
+}
+
+func TestXVerifier(t *testing.T) {
+	srv := fakeX(t, map[string][]string{
+		"follower": {"7", "8", fakeTargetID},
+		"other":    {"7", "8", "9"},
+		"limited":  {},
+	})
+	verifier := &XVerifier{Client: srv.Client(), BaseURL: srv.URL, BearerToken: fakeBearerToken, TargetUserID: fakeTargetID}
+
+	tests := []struct {
+		account string
+		want    Verification
+	}{
+		{"follower", Verified},
+		{"other", NotVerified},
+		{"unknown", NotVerified},
+		{"limited", VerificationPending},
+	}
+	for _, tt := range tests {
+		got, err := verifier.Verify(context.Background(), tt.account)
+		if err != nil || got != tt.want {
+			t.Errorf("Verify(%s) = %v, %v, want %v", tt.account, got, err, tt.want)
+		}
+	}
+
+	wrongToken := &XVerifier{Client: srv.Client(), BaseURL: srv.URL, BearerToken: "wrong", TargetUserID: fakeTargetID}
+	if got, err := wrongToken.Verify(context.Background(), "follower"); got != VerificationPending || err == nil {
+		t.Errorf("Verify with a wrong token = %v, %v, want pending with an error", got, err)
+	}
+}
+
+func TestVerifiedTaskCompletion(t *testing.T) {
+	ta := newTestApp(t)
+	fake := &fakeTelegram{members: map[string]string{"100": "left"}}
+	srv := fake.start(t)
+	ta.Verifiers = map[string]TaskVerifier{
+		verifierTelegram: &TelegramVerifier{Client: srv.Client(), BaseURL: srv.URL, Token: fakeBotToken, ChatID: fakeChatID},
+	}
+
+	id := ta.register(t, "verified@example.com", "pw")
+	token := ta.login(t, "verified@example.com", "pw")
+	path := "/users/" + strconv.Itoa(id) + "/tasks/telegram/complete"
+
+	if rec := ta.do(t, http.MethodPost, path, nil, token); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("completion without an account = %d: %s", rec.Code, rec.Body)
+	}
+	rec := ta.do(t, http.MethodPost, path, map[string]string{"account": "100"}, token)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "could not be verified") {
+		t.Errorf("completion by a non-member = %d: %s", rec.Code, rec.Body)
+	}
+
+	// the API is rate limited, the completion waits for the worker
+	fake.setStatus("100", "busy")
+	rec = ta.do(t, http.MethodPost, path, map[string]string{"account": "100"}, token)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("completion while the API is busy = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 0 {
+		t.Errorf("score while pending = %d, want 0", score)
+	}
+
+	fake.setStatus("100", "member")
+	pending, err := ta.repo.PendingTasks(context.Background(), pendingBatch)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("PendingTasks = %+v, %v", pending, err)
+	}
+	if err := ta.retryVerification(context.Background(), pending[0]); err != nil {
+		t.Fatal(err)
+	}
+	if score := ta.score(t, id); score != 50 {
+		t.Errorf("score after the retry = %d, want 50", score)
+	}
+
+	// the account verified the task for the user already
+	otherID := ta.register(t, "copycat@example.com", "pw")
+	otherToken := ta.login(t, "copycat@example.com", "pw")
+	otherPath := "/users/" + strconv.Itoa(otherID) + "/tasks/telegram/complete"
+	rec = ta.doWithHeaders(t, http.MethodPost, otherPath, map[string]string{"account": "100"}, otherToken, http.Header{"Accept": {problemContentType}})
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "account_already_used") {
+		t.Errorf("completion with the account of another user = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, otherID); score != 0 {
+		t.Errorf("score of the other user = %d, want 0", score)
+	}
+
+	// tasks without a configured verifier are trusted
+	if rec := ta.do(t, http.MethodPost, "/users/"+strconv.Itoa(id)+"/task/XSign", nil, token); rec.Code != http.StatusOK {
+		t.Errorf("completion of an unverified task = %d: %s", rec.Code, rec.Body)
+	}
+	if score := ta.score(t, id); score != 50+75 {
+		t.Errorf("score = %d, want %d", score, 50+75)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Workers runs the background goroutines of the service, so they can be stopped
+// together when the service shuts down
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewWorkers returns an empty set of workers
+func NewWorkers(logger *slog.Logger) *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{
+		ctx:     ctx,
+		cancel:  cancel,
+		logger:  logger,
+		running: make(map[string]bool),
+	}
+}
+
+// Go starts fn in its own goroutine, fn must return once its context is cancelled
+func (w *Workers) Go(name string, fn func(ctx context.Context)) {
+	w.setRunning(name, true)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.logger.Info("Worker started", "worker", name)
+		fn(w.ctx)
+		w.setRunning(name, false)
+		w.logger.Info("Worker stopped", "worker", name)
+	}()
+}
+
+func (w *Workers) setRunning(name string, running bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running[name] = running
+}
+
+// Check returns an error naming every worker which exited before the service was stopped
+func (w *Workers) Check() error {
+	if w.ctx.Err() != nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var stopped []string
+	for name, running := range w.running {
+		if !running {
+			stopped = append(stopped, name)
+		}
+	}
+	if len(stopped) == 0 {
+		return nil
+	}
+	sort.Strings(stopped)
+
+	return fmt.Errorf("workers not running: %s", strings.Join(stopped, ", "))
+}
+
+// Stop cancels all workers and waits for them until ctx is done
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
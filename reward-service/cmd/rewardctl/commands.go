@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// the password rules of the API, bcrypt ignores everything after 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// userFlags registers the flags selecting the user a command works on
+func userFlags(fs *flag.FlagSet) (*int, *string) {
+	id := fs.Int("id", 0, "id of the user")
+	email := fs.String("email", "", "email of the user, used when -id is not given")
+	return id, email
+}
+
+// findUser loads the user selected by id or email
+func (cli *CLI) findUser(ctx context.Context, id int, email string) (*data.User, error) {
+	switch {
+	case id > 0:
+		return cli.Repo.GetOne(ctx, id)
+	case email != "":
+		return cli.Repo.GetByEmail(ctx, email)
+	default:
+		return nil, errors.New("either -id or -email is required")
+	}
+}
+
+// readPassword returns the password flag, or reads one line from the input when it is empty,
+// so passwords don't have to end up in the shell history
+func (cli *CLI) readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(cli.In).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given, pass -password or write it to stdin")
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// checkPassword applies the password rules of the API
+func checkPassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("the password must be at least %d characters long", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("the password must be at most %d bytes long", maxPasswordBytes)
+	}
+
+	return nil
+}
+
+// createUser creates a new user
+func createUser(ctx context.Context, cli *CLI, args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the new user")
+	password := fs.String("password", "", "password of the new user, read from stdin when empty")
+	firstName := fs.String("first-name", "", "first name")
+	lastName := fs.String("last-name", "", "last name")
+	admin := fs.Bool("admin", false, "create the user with the admin role")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	plain, err := cli.readPassword(*password)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(plain); err != nil {
+		return err
+	}
+
+	user := data.User{
+		Email:     *email,
+		FirstName: *firstName,
+		LastName:  *lastName,
+		Password:  plain,
+		Active:    1,
+		Role:      data.RoleUser,
+	}
+	if *admin {
+		user.Role = data.RoleAdmin
+	}
+
+	id, err := cli.Repo.Insert(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	cli.Logger.Info("User created", "user_id", id, "role", user.Role)
+	fmt.Fprintln(cli.Out, id)
+
+	return nil
+}
+
+// setRole returns the command which gives a user the role
+func setRole(role string) command {
+	return func(ctx context.Context, cli *CLI, args []string) error {
+		fs := flag.NewFlagSet("role", flag.ContinueOnError)
+		id, email := userFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		user, err := cli.findUser(ctx, *id, *email)
+		if err != nil {
+			return err
+		}
+
+		user.Role = role
+		if err := cli.Repo.Update(ctx, *user); err != nil {
+			return err
+		}
+
+		cli.Logger.Info("Role changed", "user_id", user.ID, "role", role)
+		return nil
+	}
+}
+
+// changePoints returns the command which grants (sign 1) or revokes (sign -1) points
+func changePoints(sign int) command {
+	return func(ctx context.Context, cli *CLI, args []string) error {
+		fs := flag.NewFlagSet("points", flag.ContinueOnError)
+		id, email := userFlags(fs)
+		points := fs.Int("points", 0, "number of points, must be positive")
+		reason := fs.String("reason", "", "why the points are changed")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if *points <= 0 {
+			return errors.New("-points must be positive")
+		}
+		if strings.TrimSpace(*reason) == "" {
+			return errors.New("-reason is required")
+		}
+
+		user, err := cli.findUser(ctx, *id, *email)
+		if err != nil {
+			return err
+		}
+
+		delta := sign * *points
+		err = cli.Repo.AddPoints(ctx, user.ID, delta, data.PointChange{Source: data.SourceAdmin, Reason: *reason})
+		if errors.Is(err, data.ErrInsufficientPoints) {
+			return fmt.Errorf("user %d has only %d points, can't revoke %d", user.ID, user.Score, *points)
+		}
+		if err != nil {
+			return err
+		}
+
+		cli.Logger.Info("Points changed", "user_id", user.ID, "delta", delta, "reason", *reason)
+		return nil
+	}
+}
+
+// resetPassword sets a new password for a user
+func resetPassword(ctx context.Context, cli *CLI, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	id, email := userFlags(fs)
+	password := fs.String("password", "", "the new password, read from stdin when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := cli.findUser(ctx, *id, *email)
+	if err != nil {
+		return err
+	}
+
+	plain, err := cli.readPassword(*password)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(plain); err != nil {
+		return err
+	}
+
+	if err := cli.Repo.ResetPassword(ctx, plain, *user); err != nil {
+		return err
+	}
+
+	cli.Logger.Info("Password reset", "user_id", user.ID)
+	return nil
+}
+
+// setActive returns the command which activates (1) or deactivates (0) a user
+func setActive(active int) command {
+	return func(ctx context.Context, cli *CLI, args []string) error {
+		fs := flag.NewFlagSet("active", flag.ContinueOnError)
+		id, email := userFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		user, err := cli.findUser(ctx, *id, *email)
+		if err != nil {
+			return err
+		}
+
+		user.Active = active
+		if err := cli.Repo.Update(ctx, *user); err != nil {
+			return err
+		}
+
+		cli.Logger.Info("Account state changed", "user_id", user.ID, "active", active)
+		return nil
+	}
+}
+
+// exportLeaderboard writes all users sorted by score
+func exportLeaderboard(ctx context.Context, cli *CLI, args []string) error {
+	fs := flag.NewFlagSet("export-leaderboard", flag.ContinueOnError)
+	format := fs.String("format", "csv", "output format: csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	users, err := cli.Repo.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(cli.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	case "csv":
+		w := csv.NewWriter(cli.Out)
+		w.Write([]string{"rank", "id", "email", "first_name", "last_name", "score", "active"})
+		for i, user := range users {
+			w.Write([]string{
+				strconv.Itoa(i + 1),
+				strconv.Itoa(user.ID),
+				user.Email,
+				user.FirstName,
+				user.LastName,
+				strconv.Itoa(user.Score),
+				strconv.Itoa(user.Active),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestCLI returns the commands wired to an in-memory repository, with the input
+func newTestCLI(in string) (*CLI, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	cli := &CLI{
+		Repo:   data.NewMemoryRepository(),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Out:    out,
+		In:     strings.NewReader(in),
+	}
+
+	return cli, out
+}
+
+// createTestUser runs create-user and returns the id it printed
+func createTestUser(t *testing.T, cli *CLI, out *bytes.Buffer, args ...string) int {
+	t.Helper()
+
+	out.Reset()
+	if err := createUser(context.Background(), cli, args); err != nil {
+		t.Fatal(err)
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("create-user printed %q", out)
+	}
+
+	return id
+}
+
+func TestCreateUser(t *testing.T) {
+	ctx := context.Background()
+	cli, out := newTestCLI("from-stdin-pw\n")
+
+	id := createTestUser(t, cli, out, "-email", "root@example.com", "-admin")
+	user, err := cli.Repo.GetByEmail(ctx, "root@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id || !user.IsAdmin() || user.Active != 1 {
+		t.Errorf("created user = %+v", user)
+	}
+	if ok, err := cli.Repo.PasswordMatches("from-stdin-pw", *user); err != nil || !ok {
+		t.Errorf("password read from stdin doesn't match: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{"too short", "short"},
+		{"too short in characters", "äöüß"},
+		{"longer than 72 bytes", strings.Repeat("ä", 37)},
+	}
+	for _, tt := range tests {
+		err := createUser(ctx, cli, []string{"-email", "weak@example.com", "-password", tt.password})
+		if err == nil {
+			t.Errorf("%s: password %q was accepted", tt.name, tt.password)
+		}
+	}
+	if _, err := cli.Repo.GetByEmail(ctx, "weak@example.com"); err == nil {
+		t.Error("a user with a refused password was stored")
+	}
+
+	if err := createUser(ctx, cli, []string{"-password", "long-enough"}); err == nil {
+		t.Error("create-user without -email succeeded")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	cli, out := newTestCLI("")
+	id := createTestUser(t, cli, out, "-email", "reset@example.com", "-password", "first-password")
+
+	if err := resetPassword(ctx, cli, []string{"-id", strconv.Itoa(id), "-password", "short"}); err == nil {
+		t.Error("a short password was accepted")
+	}
+	if err := resetPassword(ctx, cli, []string{"-email", "reset@example.com", "-password", "second-password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := cli.Repo.GetByEmail(ctx, "reset@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := cli.Repo.PasswordMatches("second-password", *user); !ok {
+		t.Error("the new password doesn't match")
+	}
+}
+
+func TestSetActiveAndRole(t *testing.T) {
+	ctx := context.Background()
+	cli, out := newTestCLI("")
+	id := createTestUser(t, cli, out, "-email", "flip@example.com", "-password", "flip-password")
+	args := []string{"-id", strconv.Itoa(id)}
+
+	for _, run := range []command{setActive(0), setRole(data.RoleAdmin)} {
+		if err := run(ctx, cli, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, err := cli.Repo.GetOne(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Active != 0 || !user.IsAdmin() {
+		t.Errorf("after deactivate and promote = %+v", user)
+	}
+
+	if err := setActive(1)(ctx, cli, []string{}); err == nil {
+		t.Error("activate without -id or -email succeeded")
+	}
+}
+
+func TestChangePointsAndExport(t *testing.T) {
+	ctx := context.Background()
+	cli, out := newTestCLI("")
+	id := createTestUser(t, cli, out, "-email", "points@example.com", "-password", "points-password")
+	args := []string{"-id", strconv.Itoa(id), "-points", "30", "-reason", "support ticket"}
+
+	if err := changePoints(1)(ctx, cli, args); err != nil {
+		t.Fatal(err)
+	}
+	args[3] = "50"
+	if err := changePoints(-1)(ctx, cli, args); err == nil {
+		t.Error("revoking more points than the user has succeeded")
+	}
+	if err := changePoints(1)(ctx, cli, []string{"-id", strconv.Itoa(id), "-points", "5"}); err == nil {
+		t.Error("grant without -reason succeeded")
+	}
+
+	out.Reset()
+	if err := exportLeaderboard(ctx, cli, []string{"-format", "csv"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "1,"+strconv.Itoa(id)+",points@example.com,") {
+		t.Errorf("csv export = %q", out)
+	}
+	if err := exportLeaderboard(ctx, cli, []string{"-format", "xml"}); err == nil {
+		t.Error("unknown export format succeeded")
+	}
+}
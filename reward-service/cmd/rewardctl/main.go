@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reward-service/config"
+	"reward-service/data"
+	"syscall"
+)
+
+const usage = `rewardctl operates the reward service database through the same repository as the HTTP API.
+
+usage: rewardctl [config flags] <command> [command flags]
+
+commands:
+  create-user         create a user, optionally as admin
+  promote             give a user the admin role
+  demote              take the admin role away from a user
+  grant               add points to a user, with a reason
+  revoke              remove points from a user, with a reason
+  reset-password      set a new password for a user
+  deactivate          deactivate a user account
+  activate            reactivate a user account
+  export-leaderboard  write the leaderboard as CSV or JSON
+
+Run "rewardctl <command> -h" for the flags of a command.
+`
+
+// CLI holds what every command needs
+type CLI struct {
+	Repo   data.Repository
+	Logger *slog.Logger
+	Out    io.Writer
+	In     io.Reader
+}
+
+type command func(ctx context.Context, cli *CLI, args []string) error
+
+var commands = map[string]command{
+	"create-user":        createUser,
+	"promote":            setRole(data.RoleAdmin),
+	"demote":             setRole(data.RoleUser),
+	"grant":              changePoints(1),
+	"revoke":             changePoints(-1),
+	"reset-password":     resetPassword,
+	"deactivate":         setActive(0),
+	"activate":           setActive(1),
+	"export-leaderboard": exportLeaderboard,
+}
+
+// main loads the service configuration and runs one admin command
+func main() {
+	settings, args, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, usage)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	run, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+
+	level, _ := settings.Level()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	conn, err := openDB(ctx, settings.DSN)
+	if err != nil {
+		logger.Error("Couldn't connect to the database", "error", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	repo := data.NewRepository(settings.DSN, conn, settings.PoolOptions(), nil)
+
+	cli := &CLI{
+		Repo:   repo,
+		Logger: logger,
+		Out:    os.Stdout,
+		In:     os.Stdin,
+	}
+
+	err = run(ctx, cli, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Error("Command failed", "command", args[0], "error", err)
+		stop()
+		conn.Close()
+		os.Exit(1)
+	}
+}
+
+// openDB opens the pool and makes sure the database answers
+func openDB(ctx context.Context, dsn string) (*sql.DB, error) {
+	db, err := data.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,597 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"reward-service/data"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "xxxxx"
+
+// Config is the typed configuration of the reward service. Values are resolved
+// in the order defaults, config file, environment and finally command-line flags.
+type Config struct {
+	WebPort         string        `yaml:"web_port" toml:"web_port"`
+	DSN             string        `yaml:"dsn" toml:"dsn"`
+	JWTSecret       string        `yaml:"jwt_secret" toml:"jwt_secret"`
+	TokenTTL        time.Duration `yaml:"token_ttl" toml:"token_ttl"`
+	DBTimeout       time.Duration `yaml:"db_timeout" toml:"db_timeout"`
+	CORSOrigins     []string      `yaml:"cors_origins" toml:"cors_origins"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	LogLevel        string        `yaml:"log_level" toml:"log_level"`
+	LogFormat       string        `yaml:"log_format" toml:"log_format"`
+	TraceExporter   string        `yaml:"trace_exporter" toml:"trace_exporter"`
+	OTLPEndpoint    string        `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	TraceSampling   float64       `yaml:"trace_sampling" toml:"trace_sampling"`
+	AutoMigrate     bool          `yaml:"auto_migrate" toml:"auto_migrate"`
+	RequestTimeout  time.Duration `yaml:"request_timeout" toml:"request_timeout"`
+	DBMaxOpenConns  int           `yaml:"db_max_open_conns" toml:"db_max_open_conns"`
+	DBMaxIdleConns  int           `yaml:"db_max_idle_conns" toml:"db_max_idle_conns"`
+	DBConnLifetime  time.Duration `yaml:"db_conn_lifetime" toml:"db_conn_lifetime"`
+	DBConnIdleTime  time.Duration `yaml:"db_conn_idle_time" toml:"db_conn_idle_time"`
+	ReplicaDSN      string        `yaml:"replica_dsn" toml:"replica_dsn"`
+	ReplicaCheck    time.Duration `yaml:"replica_check_interval" toml:"replica_check_interval"`
+	IdempotencyTTL  time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+	BcryptCost      int           `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	TelegramToken   string        `yaml:"telegram_bot_token" toml:"telegram_bot_token"`
+	TelegramChatID  string        `yaml:"telegram_chat_id" toml:"telegram_chat_id"`
+	TelegramAPIURL  string        `yaml:"telegram_api_url" toml:"telegram_api_url"`
+	XBearerToken    string        `yaml:"x_bearer_token" toml:"x_bearer_token"`
+	XTargetUserID   string        `yaml:"x_target_user_id" toml:"x_target_user_id"`
+	XAPIURL         string        `yaml:"x_api_url" toml:"x_api_url"`
+	VerifyRetry     time.Duration `yaml:"verify_retry_interval" toml:"verify_retry_interval"`
+	BlobDir         string        `yaml:"blob_dir" toml:"blob_dir"`
+	MaxImageSize    int           `yaml:"max_image_size" toml:"max_image_size"`
+	CheckinRewards  []int         `yaml:"checkin_rewards" toml:"checkin_rewards"`
+	CheckinGrace    time.Duration `yaml:"checkin_grace" toml:"checkin_grace"`
+	FreezeEvery     int           `yaml:"streak_freeze_every" toml:"streak_freeze_every"`
+	MaxFreezes      int           `yaml:"max_streak_freezes" toml:"max_streak_freezes"`
+	TimezoneEvery   time.Duration `yaml:"timezone_change_interval" toml:"timezone_change_interval"`
+}
+
+// Default returns the configuration used when nothing else is provided
+func Default() Config {
+	return Config{
+		WebPort:         "82",
+		DSN:             "host=postgres port=5432 dbname=users user=postgres password=password",
+		TokenTTL:        15 * time.Minute,
+		DBTimeout:       3 * time.Second,
+		CORSOrigins:     []string{"https://*", "http://*"},
+		ShutdownTimeout: 15 * time.Second,
+		LogLevel:        "info",
+		LogFormat:       "text",
+		TraceExporter:   "none",
+		TraceSampling:   1,
+		RequestTimeout:  10 * time.Second,
+		DBMaxOpenConns:  25,
+		DBMaxIdleConns:  25,
+		DBConnLifetime:  30 * time.Minute,
+		DBConnIdleTime:  5 * time.Minute,
+		ReplicaCheck:    5 * time.Second,
+		IdempotencyTTL:  24 * time.Hour,
+		BcryptCost:      12,
+		TelegramAPIURL:  "https://api.telegram.org",
+		XAPIURL:         "https://api.twitter.com",
+		VerifyRetry:     time.Minute,
+		BlobDir:         "uploads",
+		MaxImageSize:    5 << 20,
+		CheckinRewards:  []int{10, 15, 20, 25, 30, 40, 50},
+		CheckinGrace:    2 * time.Hour,
+		FreezeEvery:     7,
+		MaxFreezes:      2,
+		TimezoneEvery:   7 * 24 * time.Hour,
+	}
+}
+
+// Load builds the configuration from the optional config file, the environment and
+// the given command-line arguments, validates the settings shared by all commands and
+// returns the arguments left after flags
+func Load(name string, args []string) (*Config, []string, error) {
+	// the flags are parsed into the defaults only so -h shows them, the values come from cfg
+	parsed := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	registerFlags(fs, &parsed)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// replay only the flags that were given explicitly, so they override file and env values
+	bound := flag.NewFlagSet(name, flag.ContinueOnError)
+	registerFlags(bound, &cfg)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || err != nil {
+			return
+		}
+		err = bound.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg, fs.Args(), nil
+}
+
+// registerFlags binds the command-line flags to the fields of cfg
+func registerFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.WebPort, "port", cfg.WebPort, "port the HTTP server listens on")
+	fs.StringVar(&cfg.DSN, "dsn", cfg.DSN, "database connection string")
+	fs.StringVar(&cfg.JWTSecret, "jwt-secret", cfg.JWTSecret, "secret used to sign access tokens")
+	fs.DurationVar(&cfg.TokenTTL, "token-ttl", cfg.TokenTTL, "lifetime of access tokens")
+	fs.DurationVar(&cfg.DBTimeout, "db-timeout", cfg.DBTimeout, "timeout of a single database query")
+	fs.Var((*listValue)(&cfg.CORSOrigins), "cors-origins", "comma separated list of allowed CORS origins")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time to drain requests on shutdown")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: text or json")
+	fs.StringVar(&cfg.TraceExporter, "trace-exporter", cfg.TraceExporter, "trace exporter: none, stdout or otlp")
+	fs.StringVar(&cfg.OTLPEndpoint, "otlp-endpoint", cfg.OTLPEndpoint, "host:port of the OTLP/HTTP trace collector")
+	fs.Float64Var(&cfg.TraceSampling, "trace-sampling", cfg.TraceSampling, "fraction of traces to sample, from 0 to 1")
+	fs.BoolVar(&cfg.AutoMigrate, "auto-migrate", cfg.AutoMigrate, "apply pending migrations on startup")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", cfg.RequestTimeout, "deadline of a single HTTP request")
+	fs.IntVar(&cfg.DBMaxOpenConns, "db-max-open-conns", cfg.DBMaxOpenConns, "maximum number of open database connections")
+	fs.IntVar(&cfg.DBMaxIdleConns, "db-max-idle-conns", cfg.DBMaxIdleConns, "maximum number of idle database connections")
+	fs.DurationVar(&cfg.DBConnLifetime, "db-conn-lifetime", cfg.DBConnLifetime, "maximum lifetime of a database connection")
+	fs.DurationVar(&cfg.DBConnIdleTime, "db-conn-idle-time", cfg.DBConnIdleTime, "maximum idle time of a database connection")
+	fs.StringVar(&cfg.ReplicaDSN, "replica-dsn", cfg.ReplicaDSN, "connection string of a read replica, empty disables it")
+	fs.DurationVar(&cfg.ReplicaCheck, "replica-check-interval", cfg.ReplicaCheck, "how often the health of the read replica is checked")
+	fs.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", cfg.IdempotencyTTL, "how long the response to an Idempotency-Key is replayed")
+	fs.IntVar(&cfg.BcryptCost, "bcrypt-cost", cfg.BcryptCost, "bcrypt cost of the password hashes")
+	fs.StringVar(&cfg.TelegramToken, "telegram-bot-token", cfg.TelegramToken, "token of the Telegram bot verifying channel subscriptions, empty disables the verification")
+	fs.StringVar(&cfg.TelegramChatID, "telegram-chat-id", cfg.TelegramChatID, "id or @username of the Telegram channel users subscribe to")
+	fs.StringVar(&cfg.TelegramAPIURL, "telegram-api-url", cfg.TelegramAPIURL, "base URL of the Telegram Bot API")
+	fs.StringVar(&cfg.XBearerToken, "x-bearer-token", cfg.XBearerToken, "bearer token of the X API verifying follows, empty disables the verification")
+	fs.StringVar(&cfg.XTargetUserID, "x-target-user-id", cfg.XTargetUserID, "id of the X account users follow")
+	fs.StringVar(&cfg.XAPIURL, "x-api-url", cfg.XAPIURL, "base URL of the X API")
+	fs.DurationVar(&cfg.VerifyRetry, "verify-retry-interval", cfg.VerifyRetry, "how often pending task verifications are retried")
+	fs.StringVar(&cfg.BlobDir, "blob-dir", cfg.BlobDir, "directory the images uploaded with task submissions are stored in")
+	fs.IntVar(&cfg.MaxImageSize, "max-image-size", cfg.MaxImageSize, "largest image in bytes a task submission may upload")
+	fs.Var((*intListValue)(&cfg.CheckinRewards), "checkin-rewards", "comma separated points of the daily check-ins of a streak, the last one repeats")
+	fs.DurationVar(&cfg.CheckinGrace, "checkin-grace", cfg.CheckinGrace, "time after midnight a check-in still counts for a missed yesterday")
+	fs.IntVar(&cfg.FreezeEvery, "streak-freeze-every", cfg.FreezeEvery, "a streak of this many days earns a streak freeze, 0 disables earning them")
+	fs.IntVar(&cfg.MaxFreezes, "max-streak-freezes", cfg.MaxFreezes, "most streak freezes a user can earn")
+	fs.DurationVar(&cfg.TimezoneEvery, "timezone-change-interval", cfg.TimezoneEvery, "how long users wait between two changes of their timezone")
+}
+
+// loadFile reads a YAML or TOML file, chosen by its extension, on top of cfg
+func loadFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, cfg)
+	case ".toml":
+		err = toml.Unmarshal(content, cfg)
+	default:
+		return fmt.Errorf("unsupported config file format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv overrides cfg with the environment variables which are set
+func loadEnv(cfg *Config) error {
+	var errs []error
+	env := func(key string, apply func(string) error) {
+		value, ok, err := lookupEnv(key)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		if err := apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	env("WEB_PORT", stringSetter(&cfg.WebPort))
+	env("DSN", stringSetter(&cfg.DSN))
+	env("JWT_SECRET", stringSetter(&cfg.JWTSecret))
+	env("TOKEN_TTL", durationSetter(&cfg.TokenTTL))
+	env("DB_TIMEOUT", durationSetter(&cfg.DBTimeout))
+	env("CORS_ALLOWED_ORIGINS", (*listValue)(&cfg.CORSOrigins).Set)
+	env("SHUTDOWN_TIMEOUT", durationSetter(&cfg.ShutdownTimeout))
+	env("LOG_LEVEL", stringSetter(&cfg.LogLevel))
+	env("LOG_FORMAT", stringSetter(&cfg.LogFormat))
+	env("TRACE_EXPORTER", stringSetter(&cfg.TraceExporter))
+	env("OTLP_ENDPOINT", stringSetter(&cfg.OTLPEndpoint))
+	env("TRACE_SAMPLING", floatSetter(&cfg.TraceSampling))
+	env("AUTO_MIGRATE", boolSetter(&cfg.AutoMigrate))
+	env("REQUEST_TIMEOUT", durationSetter(&cfg.RequestTimeout))
+	env("DB_MAX_OPEN_CONNS", intSetter(&cfg.DBMaxOpenConns))
+	env("DB_MAX_IDLE_CONNS", intSetter(&cfg.DBMaxIdleConns))
+	env("DB_CONN_LIFETIME", durationSetter(&cfg.DBConnLifetime))
+	env("DB_CONN_IDLE_TIME", durationSetter(&cfg.DBConnIdleTime))
+	env("REPLICA_DSN", stringSetter(&cfg.ReplicaDSN))
+	env("REPLICA_CHECK_INTERVAL", durationSetter(&cfg.ReplicaCheck))
+	env("IDEMPOTENCY_TTL", durationSetter(&cfg.IdempotencyTTL))
+	env("BCRYPT_COST", intSetter(&cfg.BcryptCost))
+	env("TELEGRAM_BOT_TOKEN", stringSetter(&cfg.TelegramToken))
+	env("TELEGRAM_CHAT_ID", stringSetter(&cfg.TelegramChatID))
+	env("TELEGRAM_API_URL", stringSetter(&cfg.TelegramAPIURL))
+	env("X_BEARER_TOKEN", stringSetter(&cfg.XBearerToken))
+	env("X_TARGET_USER_ID", stringSetter(&cfg.XTargetUserID))
+	env("X_API_URL", stringSetter(&cfg.XAPIURL))
+	env("VERIFY_RETRY_INTERVAL", durationSetter(&cfg.VerifyRetry))
+	env("BLOB_DIR", stringSetter(&cfg.BlobDir))
+	env("MAX_IMAGE_SIZE", intSetter(&cfg.MaxImageSize))
+	env("CHECKIN_REWARDS", (*intListValue)(&cfg.CheckinRewards).Set)
+	env("CHECKIN_GRACE", durationSetter(&cfg.CheckinGrace))
+	env("STREAK_FREEZE_EVERY", intSetter(&cfg.FreezeEvery))
+	env("MAX_STREAK_FREEZES", intSetter(&cfg.MaxFreezes))
+	env("TIMEZONE_CHANGE_INTERVAL", durationSetter(&cfg.TimezoneEvery))
+
+	return errors.Join(errs...)
+}
+
+// lookupEnv returns the value of key, or the content of the file named by key_FILE,
+// which is how Docker secrets are handed to the container
+func lookupEnv(key string) (string, bool, error) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true, nil
+	}
+
+	path, ok := os.LookupEnv(key + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", key, err)
+	}
+
+	return strings.TrimSpace(string(content)), true, nil
+}
+
+func stringSetter(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func durationSetter(target *time.Duration) func(string) error {
+	return func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*target = d
+		return nil
+	}
+}
+
+func intSetter(target *int) func(string) error {
+	return func(value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*target = i
+		return nil
+	}
+}
+
+func floatSetter(target *float64) func(string) error {
+	return func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*target = f
+		return nil
+	}
+}
+
+func boolSetter(target *bool) func(string) error {
+	return func(value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*target = b
+		return nil
+	}
+}
+
+// listValue is a flag.Value holding a comma separated list
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*l = items
+	return nil
+}
+
+// intListValue is a flag.Value holding a comma separated list of numbers
+type intListValue []int
+
+func (l *intListValue) String() string {
+	if l == nil {
+		return ""
+	}
+	items := make([]string, 0, len(*l))
+	for _, item := range *l {
+		items = append(items, strconv.Itoa(item))
+	}
+	return strings.Join(items, ",")
+}
+
+func (l *intListValue) Set(value string) error {
+	var items []int
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			return err
+		}
+		items = append(items, i)
+	}
+	*l = items
+	return nil
+}
+
+// Validate reports every invalid setting needed by all commands at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.DSN == "" {
+		errs = append(errs, errors.New("dsn must be set"))
+	}
+	if path, ok := strings.CutPrefix(c.DSN, "sqlite://"); ok && (path == "" || strings.Contains(path, ":memory:")) {
+		// the migrations run on their own connection, an in-memory database would be lost
+		errs = append(errs, errors.New("sqlite dsn must name a database file"))
+	}
+	if c.DBTimeout <= 0 {
+		errs = append(errs, errors.New("db timeout must be positive"))
+	}
+	if c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 || c.DBConnLifetime < 0 || c.DBConnIdleTime < 0 {
+		errs = append(errs, errors.New("db pool settings must not be negative"))
+	}
+	if _, err := c.Level(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log format %q must be text or json", c.LogFormat))
+	}
+	switch c.TraceExporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("trace exporter %q must be none, stdout or otlp", c.TraceExporter))
+	}
+	if c.TraceSampling < 0 || c.TraceSampling > 1 {
+		errs = append(errs, errors.New("trace sampling must be between 0 and 1"))
+	}
+
+	return joinInvalid(errs)
+}
+
+// ValidateServer additionally checks the settings only the HTTP server needs
+func (c *Config) ValidateServer() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	var errs []error
+
+	if port, err := strconv.Atoi(c.WebPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("web port %q is not a valid port", c.WebPort))
+	}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("jwt secret must be set"))
+	}
+	if c.TokenTTL <= 0 {
+		errs = append(errs, errors.New("token ttl must be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if c.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("request timeout must be positive"))
+	}
+	if len(c.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("at least one CORS origin must be allowed"))
+	}
+	if c.ReplicaDSN != "" {
+		if data.BackendOf(c.ReplicaDSN) != data.BackendOf(c.DSN) {
+			errs = append(errs, errors.New("replica dsn must use the same database backend as dsn"))
+		}
+		if c.ReplicaCheck <= 0 {
+			errs = append(errs, errors.New("replica check interval must be positive"))
+		}
+	}
+	if c.IdempotencyTTL <= 0 {
+		errs = append(errs, errors.New("idempotency ttl must be positive"))
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.TelegramToken != "" && c.TelegramChatID == "" {
+		errs = append(errs, errors.New("telegram chat id must be set to verify subscriptions"))
+	}
+	if c.XBearerToken != "" && c.XTargetUserID == "" {
+		errs = append(errs, errors.New("x target user id must be set to verify follows"))
+	}
+	if c.VerifyRetry <= 0 {
+		errs = append(errs, errors.New("verify retry interval must be positive"))
+	}
+	if c.BlobDir == "" {
+		errs = append(errs, errors.New("blob dir must be set"))
+	}
+	if c.MaxImageSize <= 0 {
+		errs = append(errs, errors.New("max image size must be positive"))
+	}
+	if len(c.CheckinRewards) == 0 {
+		errs = append(errs, errors.New("checkin rewards must list the points of at least one day"))
+	}
+	for _, points := range c.CheckinRewards {
+		if points <= 0 {
+			errs = append(errs, fmt.Errorf("checkin reward %d must be positive", points))
+		}
+	}
+	if c.CheckinGrace < 0 || c.CheckinGrace >= 24*time.Hour {
+		errs = append(errs, errors.New("checkin grace must be between 0 and 24h"))
+	}
+	if c.FreezeEvery < 0 || c.MaxFreezes < 0 {
+		errs = append(errs, errors.New("streak freeze settings must not be negative"))
+	}
+	if c.TimezoneEvery < 0 {
+		errs = append(errs, errors.New("timezone change interval must not be negative"))
+	}
+
+	return joinInvalid(errs)
+}
+
+func joinInvalid(errs []error) error {
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// PoolOptions returns the database pool settings in the form the data package expects
+func (c *Config) PoolOptions() data.PoolOptions {
+	return data.PoolOptions{
+		MaxOpenConns:    c.DBMaxOpenConns,
+		MaxIdleConns:    c.DBMaxIdleConns,
+		ConnMaxLifetime: c.DBConnLifetime,
+		ConnMaxIdleTime: c.DBConnIdleTime,
+		QueryTimeout:    c.DBTimeout,
+		BcryptCost:      c.BcryptCost,
+	}
+}
+
+// Level parses the configured log level
+func (c *Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("log level %q is unknown", c.LogLevel)
+	}
+
+	return level, nil
+}
+
+var dsnPassword = regexp.MustCompile(`password=\S+`)
+
+// Redacted returns a copy of the configuration which is safe to log
+func (c Config) Redacted() Config {
+	if c.JWTSecret != "" {
+		c.JWTSecret = redacted
+	}
+	if c.TelegramToken != "" {
+		c.TelegramToken = redacted
+	}
+	if c.XBearerToken != "" {
+		c.XBearerToken = redacted
+	}
+	c.DSN = redactDSN(c.DSN)
+	c.ReplicaDSN = redactDSN(c.ReplicaDSN)
+	c.CORSOrigins = append([]string(nil), c.CORSOrigins...)
+	c.CheckinRewards = append([]int(nil), c.CheckinRewards...)
+
+	return c
+}
+
+// LogValue logs the redacted configuration, so secrets never reach the logs
+func (c Config) LogValue() slog.Value {
+	r := c.Redacted()
+	return slog.GroupValue(
+		slog.String("web_port", r.WebPort),
+		slog.String("dsn", r.DSN),
+		slog.String("jwt_secret", r.JWTSecret),
+		slog.Duration("token_ttl", r.TokenTTL),
+		slog.Duration("db_timeout", r.DBTimeout),
+		slog.Any("cors_origins", r.CORSOrigins),
+		slog.Duration("shutdown_timeout", r.ShutdownTimeout),
+		slog.String("log_level", r.LogLevel),
+		slog.String("log_format", r.LogFormat),
+		slog.String("trace_exporter", r.TraceExporter),
+		slog.String("otlp_endpoint", r.OTLPEndpoint),
+		slog.Float64("trace_sampling", r.TraceSampling),
+		slog.Bool("auto_migrate", r.AutoMigrate),
+		slog.Duration("request_timeout", r.RequestTimeout),
+		slog.Int("db_max_open_conns", r.DBMaxOpenConns),
+		slog.Int("db_max_idle_conns", r.DBMaxIdleConns),
+		slog.Duration("db_conn_lifetime", r.DBConnLifetime),
+		slog.Duration("db_conn_idle_time", r.DBConnIdleTime),
+		slog.String("replica_dsn", r.ReplicaDSN),
+		slog.Duration("replica_check_interval", r.ReplicaCheck),
+		slog.Duration("idempotency_ttl", r.IdempotencyTTL),
+		slog.Int("bcrypt_cost", r.BcryptCost),
+		slog.String("telegram_bot_token", r.TelegramToken),
+		slog.String("telegram_chat_id", r.TelegramChatID),
+		slog.String("telegram_api_url", r.TelegramAPIURL),
+		slog.String("x_bearer_token", r.XBearerToken),
+		slog.String("x_target_user_id", r.XTargetUserID),
+		slog.String("x_api_url", r.XAPIURL),
+		slog.Duration("verify_retry_interval", r.VerifyRetry),
+		slog.String("blob_dir", r.BlobDir),
+		slog.Int("max_image_size", r.MaxImageSize),
+		slog.Any("checkin_rewards", r.CheckinRewards),
+		slog.Duration("checkin_grace", r.CheckinGrace),
+		slog.Int("streak_freeze_every", r.FreezeEvery),
+		slog.Int("max_streak_freezes", r.MaxFreezes),
+		slog.Duration("timezone_change_interval", r.TimezoneEvery),
+	)
+}
+
+// redactDSN hides the password of both URL and key=value connection strings
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "password="+redacted)
+}
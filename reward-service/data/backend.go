@@ -0,0 +1,65 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// database backends, the backend is selected by the scheme of the DSN
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// sqliteScheme prefixes the DSN of a SQLite database, e.g. sqlite:///var/lib/reward/users.db
+const sqliteScheme = "sqlite://"
+
+// sqlitePragmas are set on every SQLite connection: foreign keys like Postgres, waiting
+// for locks instead of failing and WAL so readers don't block the writer
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// BackendOf returns the backend of dsn, every DSN without the sqlite:// scheme is Postgres
+func BackendOf(dsn string) string {
+	if strings.HasPrefix(dsn, sqliteScheme) {
+		return BackendSQLite
+	}
+
+	return BackendPostgres
+}
+
+// Open opens a pool on the database behind dsn with the driver of its backend,
+// like sql.Open it doesn't connect yet
+func Open(dsn string) (*sql.DB, error) {
+	if BackendOf(dsn) == BackendSQLite {
+		return sql.Open("sqlite", sqliteDSN(dsn))
+	}
+
+	return sql.Open("pgx/v4", dsn)
+}
+
+// NewRepository returns the repository of the backend of dsn on pool
+func NewRepository(dsn string, pool *sql.DB, opts PoolOptions, observer QueryObserver) Repository {
+	if BackendOf(dsn) == BackendSQLite {
+		repo := NewSQLiteRepositoryWithOptions(pool, opts)
+		repo.Observer = observer
+		return repo
+	}
+
+	repo := NewPostgresRepositoryWithOptions(pool, opts)
+	repo.Observer = observer
+	return repo
+}
+
+// sqliteDSN turns sqlite://path?params into the file name the driver expects
+// and adds the connection pragmas
+func sqliteDSN(dsn string) string {
+	path := strings.TrimPrefix(dsn, sqliteScheme)
+	if strings.Contains(path, "?") {
+		return path + "&" + sqlitePragmas
+	}
+
+	return path + "?" + sqlitePragmas
+}
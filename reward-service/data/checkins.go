@@ -0,0 +1,310 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SourceCheckin is the ledger source of the points of the daily check-ins
+const SourceCheckin = "checkin"
+
+// dayLayout is the form of the calendar days of the check-ins
+const dayLayout = time.DateOnly
+
+// CheckinLog is the storage of the daily check-ins and the streaks of the users
+type CheckinLog interface {
+	// CheckIn records the check-in of the user on their current day and awards its points
+	CheckIn(ctx context.Context, userID int, rules CheckinRules, change PointChange) (*Checkin, error)
+	// Streak returns the streak of the user as it stands now
+	Streak(ctx context.Context, userID int, rules CheckinRules) (*Streak, error)
+	// GrantStreakFreezes gives the user more streak freezes
+	GrantStreakFreezes(ctx context.Context, userID, count int) (*Streak, error)
+}
+
+// CheckinRules decide the points of a check-in and when a streak survives missed days.
+// Rewards are the points of the first, second and later days of a streak, the last one
+// repeats. A check-in less than Grace after midnight counts for yesterday when the user
+// missed it and the streak goes on from the day before. A streak freeze covers one missed
+// day, a streak of every FreezeEvery days earns one while the user has less than MaxFreezes.
+type CheckinRules struct {
+	Rewards     []int
+	Grace       time.Duration
+	FreezeEvery int
+	MaxFreezes  int
+}
+
+// Reward returns the points of the check-in on the day of a streak
+func (r CheckinRules) Reward(day int) int {
+	if len(r.Rewards) == 0 {
+		return 0
+	}
+
+	return r.Rewards[min(max(day, 1), len(r.Rewards))-1]
+}
+
+// Streak is the streak of daily check-ins of a user. LastDay is the calendar day of the
+// last check-in in the timezone of the user.
+type Streak struct {
+	Current int    `json:"current_streak"`
+	Longest int    `json:"longest_streak"`
+	Freezes int    `json:"streak_freezes"`
+	LastDay string `json:"last_checkin_day,omitempty"`
+}
+
+// Checkin is the check-in of a user on a calendar day, Streak is the day of the streak
+// it continued and FreezesUsed the streak freezes it took
+type Checkin struct {
+	ID           int64     `json:"id"`
+	UserID       int       `json:"user_id"`
+	Day          string    `json:"day"`
+	Streak       int       `json:"streak"`
+	Points       int       `json:"points"`
+	FreezesUsed  int       `json:"freezes_used"`
+	FreezeEarned bool      `json:"freeze_earned,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// frozen returns how many streak freezes the streak needs to go on with a check-in on the
+// calendar day, ok is false when it is broken
+func (r CheckinRules) frozen(s Streak, day string) (freezes int, ok bool) {
+	if s.Current == 0 || s.LastDay == "" {
+		return 0, false
+	}
+	last, err := time.Parse(dayLayout, s.LastDay)
+	if err != nil {
+		return 0, false
+	}
+	next, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return 0, false
+	}
+
+	missed := int(next.Sub(last).Hours()/24) - 1
+	if missed > s.Freezes {
+		return 0, false
+	}
+
+	return max(missed, 0), true
+}
+
+// day returns the calendar day a check-in at the time counts for. It is today, or
+// yesterday for a check-in less than Grace after midnight which continues the streak on
+// it, when yesterday has no check-in.
+func (r CheckinRules) day(s Streak, now time.Time, periods Periods) string {
+	today := periods.DayStart.Format(dayLayout)
+	if now.Sub(periods.DayStart) >= r.Grace {
+		return today
+	}
+	yesterday := periods.DayStart.AddDate(0, 0, -1).Format(dayLayout)
+	if s.LastDay >= yesterday {
+		return today
+	}
+	if _, ok := r.frozen(s, yesterday); !ok {
+		return today
+	}
+
+	return yesterday
+}
+
+// current returns the streak at the time, its current streak is zero once it is broken
+func (r CheckinRules) current(s Streak, now time.Time, loc *time.Location) Streak {
+	if _, ok := r.frozen(s, r.day(s, now, PeriodsAt(now, loc))); !ok {
+		s.Current = 0
+	}
+
+	return s
+}
+
+// next returns the check-in of the user with the streak at the time and the streak after
+// it, or a *CooldownError when the user already checked in today. The day of the last
+// check-in may be later than today after the user moved to a timezone further west, they
+// check in again once their today is past it.
+func (r CheckinRules) next(s Streak, now time.Time, loc *time.Location) (*Checkin, Streak, error) {
+	periods := PeriodsAt(now, loc)
+	if s.LastDay != "" && periods.DayStart.Format(dayLayout) <= s.LastDay {
+		last, err := time.ParseInLocation(dayLayout, s.LastDay, loc)
+		if err != nil {
+			return nil, s, err
+		}
+		return nil, s, &CooldownError{Err: ErrCheckedIn, AvailableAt: last.AddDate(0, 0, 1)}
+	}
+
+	day := r.day(s, now, periods)
+	checkin := &Checkin{Day: day, CreatedAt: now}
+	if freezes, ok := r.frozen(s, day); ok {
+		checkin.FreezesUsed = freezes
+		s.Freezes -= freezes
+		s.Current++
+	} else {
+		s.Current = 1
+	}
+	s.Longest = max(s.Longest, s.Current)
+	s.LastDay = day
+	if r.FreezeEvery > 0 && s.Current%r.FreezeEvery == 0 && s.Freezes < r.MaxFreezes {
+		s.Freezes++
+		checkin.FreezeEarned = true
+	}
+	checkin.Streak = s.Current
+	checkin.Points = r.Reward(s.Current)
+
+	return checkin, s, nil
+}
+
+// CheckIn records the check-in of the user on their current day and awards its points
+// in one transaction. The user row is locked first, so two check-ins of the same day are
+// serialized and the second one is refused.
+func (u *sqlRepository) CheckIn(ctx context.Context, userID int, rules CheckinRules, change PointChange) (checkin *Checkin, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "CheckIn")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var user User
+	err = tx.QueryRowContext(ctx, `update users set score = score where id = $1 returning timezone`, userID).Scan(&user.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	streak, err := readStreak(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	checkin, next, err := rules.next(*streak, now, user.Location())
+	if err != nil {
+		return nil, err
+	}
+	checkin.UserID = userID
+
+	stmt := `insert into checkins (user_id, day, streak, points, freezes_used, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+	err = tx.QueryRowContext(ctx, stmt, userID, checkin.Day, checkin.Streak, checkin.Points, checkin.FreezesUsed, now).Scan(&checkin.ID)
+	if u.isUniqueViolation(err) {
+		return nil, ErrCheckedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := writeStreak(ctx, tx, userID, next, now); err != nil {
+		return nil, err
+	}
+
+	var score int
+	err = tx.QueryRowContext(ctx, `update users set score = score + $1, updated_at = $2 where id = $3 returning score`,
+		checkin.Points, time.Now(), userID).Scan(&score)
+	if err != nil {
+		return nil, err
+	}
+	change.Reference = checkin.Day
+	if err := recordTransaction(ctx, tx, userID, checkin.Points, score, change); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return checkin, nil
+}
+
+// Streak returns the streak of the user, its current streak is zero once it is broken
+func (u *sqlRepository) Streak(ctx context.Context, userID int, rules CheckinRules) (streak *Streak, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "Streak")
+	defer func() { done(err) }()
+
+	var user User
+	err = u.read(ctx, func(db *sql.DB) error {
+		err := db.QueryRowContext(ctx, `select timezone from users where id = $1`, userID).Scan(&user.Timezone)
+		if err != nil {
+			return err
+		}
+		streak, err = readStreak(ctx, db, userID)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current := rules.current(*streak, time.Now(), user.Location())
+	return &current, nil
+}
+
+// GrantStreakFreezes adds count streak freezes to the user and returns the stored streak
+func (u *sqlRepository) GrantStreakFreezes(ctx context.Context, userID, count int) (streak *Streak, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "GrantStreakFreezes")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `update users set score = score where id = $1 returning id`, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	streak, err = readStreak(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	streak.Freezes += count
+	if err := writeStreak(ctx, tx, userID, *streak, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return streak, nil
+}
+
+// readStreak returns the stored streak of the user, a user who never checked in has none
+func readStreak(ctx context.Context, db rowQuerier, userID int) (*Streak, error) {
+	var s Streak
+	err := db.QueryRowContext(ctx, `select current_streak, longest_streak, freezes, last_day from streaks where user_id = $1`, userID).
+		Scan(&s.Current, &s.Longest, &s.Freezes, &s.LastDay)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// writeStreak stores the streak of the user
+func writeStreak(ctx context.Context, tx *sql.Tx, userID int, s Streak, now time.Time) error {
+	stmt := `insert into streaks (user_id, current_streak, longest_streak, freezes, last_day, updated_at)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (user_id) do update set
+			current_streak = excluded.current_streak,
+			longest_streak = excluded.longest_streak,
+			freezes = excluded.freezes,
+			last_day = excluded.last_day,
+			updated_at = excluded.updated_at`
+	_, err := tx.ExecContext(ctx, stmt, userID, s.Current, s.Longest, s.Freezes, s.LastDay, now)
+
+	return err
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckinRules(t *testing.T) {
+	rules := CheckinRules{Rewards: []int{10, 20, 30}, Grace: 2 * time.Hour, FreezeEvery: 3, MaxFreezes: 1}
+	day := func(d, hour int) time.Time { return time.Date(2025, 3, d, hour, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name    string
+		at      time.Time
+		streak  int
+		points  int
+		freezes int // streak freezes left after the check-in
+	}{
+		{"first day", day(10, 9), 1, 10, 0},
+		{"next day", day(11, 23), 2, 20, 0},
+		{"third day earns a freeze", day(12, 8), 3, 30, 1},
+		{"the last reward repeats", day(13, 8), 4, 30, 1},
+		{"a missed day takes the freeze", day(15, 12), 5, 30, 0},
+		{"grace after midnight counts for yesterday", day(17, 1), 6, 30, 1},
+		{"and today is still open", day(17, 12), 7, 30, 1},
+		{"broken streak", day(20, 12), 1, 10, 1},
+	}
+
+	var streak Streak
+	for _, tt := range tests {
+		checkin, next, err := rules.next(streak, tt.at, time.UTC)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if checkin.Streak != tt.streak || checkin.Points != tt.points || next.Freezes != tt.freezes || next.Current != tt.streak {
+			t.Errorf("%s: check-in %+v, streak %+v", tt.name, checkin, next)
+		}
+		streak = next
+	}
+	if streak.Longest != 7 || streak.LastDay != "2025-03-20" {
+		t.Errorf("streak = %+v", streak)
+	}
+
+	var cooldown *CooldownError
+	if _, _, err := rules.next(streak, day(20, 23), time.UTC); !errors.As(err, &cooldown) || !errors.Is(err, ErrCheckedIn) || !cooldown.AvailableAt.Equal(day(21, 0)) {
+		t.Errorf("second check-in of the day = %v", err)
+	}
+	if current := rules.current(streak, day(21, 12), time.UTC); current.Current != 1 {
+		t.Errorf("current streak the next day = %+v", current)
+	}
+	if current := rules.current(streak, day(23, 12), time.UTC); current.Current != 0 || current.Longest != 7 {
+		t.Errorf("current streak after two missed days with one freeze = %+v", current)
+	}
+
+	// the calendar day is the one of the timezone of the user
+	tokyo := time.FixedZone("UTC+9", 9*60*60)
+	checkin, _, err := rules.next(Streak{}, day(20, 20), tokyo)
+	if err != nil || checkin.Day != "2025-03-21" {
+		t.Errorf("check-in in UTC+9 = %+v, %v", checkin, err)
+	}
+
+	// the grace after midnight covers yesterday only, alternating days break the streak
+	streak = Streak{}
+	for i, d := range []int{1, 3, 5} {
+		checkin, next, err := rules.next(streak, time.Date(2025, 4, d, 0, 30, 0, 0, time.UTC), time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{1, 2, 1}[i]; checkin.Streak != want {
+			t.Errorf("check-in at 00:30 of day %d = %+v, want streak %d", d, checkin, want)
+		}
+		streak = next
+	}
+
+	// moving west puts today before the last check-in, the user waits for the day after it
+	east := time.FixedZone("UTC+14", 14*60*60)
+	west := time.FixedZone("UTC-12", -12*60*60)
+	_, streak, err = rules.next(Streak{}, day(20, 20), east)
+	if err != nil || streak.LastDay != "2025-03-21" {
+		t.Fatalf("check-in in UTC+14 = %+v, %v", streak, err)
+	}
+	if _, _, err := rules.next(streak, day(21, 6), west); !errors.As(err, &cooldown) || !cooldown.AvailableAt.Equal(day(22, 12)) {
+		t.Errorf("check-in after moving west = %v", err)
+	}
+	if checkin, _, err := rules.next(streak, day(22, 13), west); err != nil || checkin.Day != "2025-03-22" || checkin.Streak != 2 {
+		t.Errorf("check-in the day after = %+v, %v", checkin, err)
+	}
+}
@@ -0,0 +1,409 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// statuses of a task completion
+const (
+	CompletionCompleted = "completed"
+	// CompletionPending is a completion waiting for its verification, it awarded no points yet
+	CompletionPending = "pending"
+)
+
+// TaskCompletion records that a user completed a task and the points it awarded. Account
+// is the account of the user the verifier of the task checks.
+type TaskCompletion struct {
+	ID          int64     `json:"id"`
+	UserID      int       `json:"user_id"`
+	TaskCode    string    `json:"task_code"`
+	Points      int       `json:"points"`
+	Status      string    `json:"status"`
+	Account     string    `json:"account,omitempty"`
+	CompletedAt time.Time `json:"completed_at"`
+
+	// attemptedAt is when the verification of a pending completion was last attempted
+	attemptedAt time.Time
+}
+
+// UserTask is a task of the catalog with how often a user completed it, in total and in
+// the current day and week of the user, and whether a completion is waiting for its
+// verification. AvailableAt is set while the task is on cooldown or over a limit.
+type UserTask struct {
+	Task
+	Completions       int        `json:"completions"`
+	CompletedToday    int        `json:"completed_today"`
+	CompletedThisWeek int        `json:"completed_this_week"`
+	LastCompletedAt   *time.Time `json:"last_completed_at,omitempty"`
+	AvailableAt       *time.Time `json:"available_at,omitempty"`
+	Pending           bool       `json:"pending,omitempty"`
+
+	history CompletionHistory
+}
+
+// newUserTask returns the task without completions, counted in the periods of the user
+func newUserTask(task Task, periods Periods) UserTask {
+	return UserTask{Task: task, history: CompletionHistory{Periods: periods}}
+}
+
+// Available reports whether the user may complete the task at the time
+func (t *UserTask) Available(now time.Time) bool {
+	return t.CanComplete(t.history, now) == nil
+}
+
+// settle sets when the task is available again once every completion was counted
+func (t *UserTask) settle(now time.Time) {
+	var cooldown *CooldownError
+	if errors.As(t.CanComplete(t.history, now), &cooldown) {
+		t.AvailableAt = &cooldown.AvailableAt
+	}
+}
+
+// completionState is what a completion transaction knows about the task and the user
+type completionState struct {
+	task    *Task
+	history CompletionHistory
+	// pendingID is the id of the pending completion of the user, zero without one
+	pendingID int64
+}
+
+// lockCompletion locks the user row, so concurrent completions of the same user are
+// counted one after the other, and reads the task with the completion history of the
+// user at the time
+func lockCompletion(ctx context.Context, tx *sql.Tx, userID int, code string, now time.Time) (*completionState, error) {
+	var user User
+	err := tx.QueryRowContext(ctx, `update users set score = score where id = $1 returning timezone`, userID).Scan(&user.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := scanTask(tx.QueryRowContext(ctx, `select `+taskColumns+` from tasks where code = $1`, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &completionState{task: task, history: CompletionHistory{Periods: PeriodsAt(now, user.Location())}}
+	periods := state.history.Periods
+	query := `select count(*),
+			coalesce(sum(case when completed_at >= $4 then 1 else 0 end), 0),
+			coalesce(sum(case when completed_at >= $5 then 1 else 0 end), 0)
+		from task_completions where user_id = $1 and task_id = $2 and status = $3`
+	err = tx.QueryRowContext(ctx, query, userID, task.ID, CompletionCompleted, periods.DayStart.UTC(), periods.WeekStart.UTC()).
+		Scan(&state.history.Total, &state.history.Today, &state.history.ThisWeek)
+	if err != nil {
+		return nil, err
+	}
+
+	if task.CooldownSeconds > 0 && state.history.Total > 0 {
+		query = `select completed_at from task_completions
+			where user_id = $1 and task_id = $2 and status = $3 order by completed_at desc limit 1`
+		err = tx.QueryRowContext(ctx, query, userID, task.ID, CompletionCompleted).Scan(&state.history.Last)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = `select id from task_completions where user_id = $1 and task_id = $2 and status = $3`
+	err = tx.QueryRowContext(ctx, query, userID, task.ID, CompletionPending).Scan(&state.pendingID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// CompleteTask records the completion of the task with the code and awards its points in
+// one transaction. A pending completion of the user becomes completed.
+func (u *sqlRepository) CompleteTask(ctx context.Context, userID int, code string, change PointChange) (completion *TaskCompletion, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "CompleteTask")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	completion, err = u.completeTask(ctx, tx, userID, code, change)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return completion, nil
+}
+
+// completeTask records the completion and awards its points in the transaction, every
+// path completing a task goes through it
+func (u *sqlRepository) completeTask(ctx context.Context, tx *sql.Tx, userID int, code string, change PointChange) (*TaskCompletion, error) {
+	now := time.Now().UTC()
+	state, err := lockCompletion(ctx, tx, userID, code, now)
+	if err != nil {
+		return nil, err
+	}
+	task := state.task
+	if err := task.CanComplete(state.history, now); err != nil {
+		return nil, err
+	}
+
+	completion := &TaskCompletion{UserID: userID, TaskCode: task.Code, Points: task.Points, Status: CompletionCompleted, CompletedAt: now}
+	if state.pendingID != 0 {
+		stmt := `update task_completions set status = $1, points = $2, completed_at = $3 where id = $4 returning id, account`
+		err = tx.QueryRowContext(ctx, stmt, CompletionCompleted, task.Points, now, state.pendingID).Scan(&completion.ID, &completion.Account)
+	} else {
+		stmt := `insert into task_completions (user_id, task_id, points, repeatable, status, completed_at)
+			values ($1, $2, $3, $4, $5, $6) returning id`
+		err = tx.QueryRowContext(ctx, stmt, userID, task.ID, task.Points, task.Repeatable, CompletionCompleted, now).Scan(&completion.ID)
+	}
+	if u.isUniqueViolation(err) {
+		return nil, ErrTaskCompleted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var score int
+	err = tx.QueryRowContext(ctx, `update users set score = score + $1, updated_at = $2 where id = $3 returning score`,
+		task.Points, time.Now(), userID).Scan(&score)
+	if err != nil {
+		return nil, err
+	}
+	change.Reference = task.Code
+	if err := recordTransaction(ctx, tx, userID, task.Points, score, change); err != nil {
+		return nil, err
+	}
+
+	return completion, nil
+}
+
+// SetTaskPending records a completion of the task with the code whose verification isn't
+// decided yet, without awarding points. A user has at most one pending completion of a
+// task, sending another one replaces its account. An account verifies a task for one user
+// only, the completions of other users with it make it ErrAccountTaken.
+func (u *sqlRepository) SetTaskPending(ctx context.Context, userID int, code, account string) (completion *TaskCompletion, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "SetTaskPending")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	state, err := lockCompletion(ctx, tx, userID, code, now)
+	if err != nil {
+		return nil, err
+	}
+	task := state.task
+	if err := task.CanComplete(state.history, now); err != nil {
+		return nil, err
+	}
+
+	if account != "" {
+		var owner int
+		query := `select user_id from task_completions where task_id = $1 and account = $2 and user_id <> $3 limit 1`
+		err := tx.QueryRowContext(ctx, query, task.ID, account, userID).Scan(&owner)
+		if err == nil {
+			return nil, ErrAccountTaken
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	completion = &TaskCompletion{UserID: userID, TaskCode: task.Code, Points: task.Points, Status: CompletionPending, Account: account, CompletedAt: now}
+	if state.pendingID != 0 {
+		stmt := `update task_completions set account = $1, completed_at = $2, attempted_at = $2 where id = $3`
+		_, err = tx.ExecContext(ctx, stmt, account, now, state.pendingID)
+		completion.ID = state.pendingID
+	} else {
+		stmt := `insert into task_completions (user_id, task_id, points, repeatable, status, account, completed_at, attempted_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $7) returning id`
+		err = tx.QueryRowContext(ctx, stmt, userID, task.ID, task.Points, task.Repeatable, CompletionPending, account, now).Scan(&completion.ID)
+	}
+	if u.isUniqueViolation(err) {
+		// the user row is locked, only a completion of another user with the account conflicts
+		return nil, ErrAccountTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return completion, nil
+}
+
+// PendingTasks returns up to limit pending completions, the ones attempted least recently
+// first, and marks them attempted now
+func (u *sqlRepository) PendingTasks(ctx context.Context, limit int) (completions []TaskCompletion, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "PendingTasks")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `select c.id, c.user_id, t.code, c.points, c.status, c.account, c.completed_at
+		from task_completions c join tasks t on t.id = c.task_id
+		where c.status = $1 order by c.attempted_at, c.id limit $2`
+
+	rows, err := tx.QueryContext(ctx, query, CompletionPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	completions = []TaskCompletion{}
+	for rows.Next() {
+		var c TaskCompletion
+		if err := rows.Scan(&c.ID, &c.UserID, &c.TaskCode, &c.Points, &c.Status, &c.Account, &c.CompletedAt); err != nil {
+			return nil, fmt.Errorf("scanning task completion: %w", err)
+		}
+		completions = append(completions, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	now := time.Now().UTC()
+	for _, c := range completions {
+		if _, err := tx.ExecContext(ctx, `update task_completions set attempted_at = $1 where id = $2`, now, c.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return completions, nil
+}
+
+// CancelPendingTask deletes the pending completion of the task by the user, if any
+func (u *sqlRepository) CancelPendingTask(ctx context.Context, userID int, code string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "CancelPendingTask")
+	defer func() { done(err) }()
+
+	stmt := `delete from task_completions
+		where user_id = $1 and status = $2 and task_id = (select id from tasks where code = $3)`
+	_, err = u.Conn.ExecContext(ctx, stmt, userID, CompletionPending, code)
+
+	return err
+}
+
+// UserTasks returns every task of the catalog with the completions of the user, sorted by
+// code. The limits of the tasks are evaluated now, in the timezone of the user.
+func (u *sqlRepository) UserTasks(ctx context.Context, userID int) (tasks []UserTask, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "UserTasks")
+	defer func() { done(err) }()
+
+	now := time.Now()
+	err = u.read(ctx, func(db *sql.DB) error {
+		var user User
+		err := db.QueryRowContext(ctx, `select timezone from users where id = $1`, userID).Scan(&user.Timezone)
+		if err != nil {
+			return err
+		}
+		periods := PeriodsAt(now, user.Location())
+
+		tasks = []UserTask{}
+		rows, err := db.QueryContext(ctx, `select `+taskColumns+` from tasks order by code`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		byID := make(map[int]int)
+		for rows.Next() {
+			task, err := scanTask(rows)
+			if err != nil {
+				return fmt.Errorf("scanning task: %w", err)
+			}
+			byID[task.ID] = len(tasks)
+			tasks = append(tasks, newUserTask(*task, periods))
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// aggregated here, SQLite returns the max of a timestamp as text
+		query := `select task_id, status, completed_at from task_completions where user_id = $1`
+		completions, err := db.QueryContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		defer completions.Close()
+
+		for completions.Next() {
+			var taskID int
+			var status string
+			var completedAt time.Time
+			if err := completions.Scan(&taskID, &status, &completedAt); err != nil {
+				return fmt.Errorf("scanning task completion: %w", err)
+			}
+			i, ok := byID[taskID]
+			if !ok {
+				continue
+			}
+			tasks[i].count(status, completedAt)
+		}
+
+		return completions.Err()
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		tasks[i].settle(now)
+	}
+
+	return tasks, nil
+}
+
+// count adds a completion of the task by the user
+func (t *UserTask) count(status string, completedAt time.Time) {
+	if status == CompletionPending {
+		t.Pending = true
+		return
+	}
+
+	t.history.add(completedAt)
+	t.Completions = t.history.Total
+	t.CompletedToday = t.history.Today
+	t.CompletedThisWeek = t.history.ThisWeek
+	last := t.history.Last
+	t.LastCompletedAt = &last
+}
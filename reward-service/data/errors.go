@@ -0,0 +1,58 @@
+package data
+
+import "errors"
+
+// errors returned by every Repository, callers check them with errors.Is
+var (
+	// ErrNotFound means the user the operation refers to doesn't exist
+	ErrNotFound = errors.New("user not found")
+	// ErrDuplicateEmail means another user is already registered with the email
+	ErrDuplicateEmail = errors.New("email is already registered")
+	// ErrInsufficientPoints means the change would make the score negative
+	ErrInsufficientPoints = errors.New("not enough points")
+	// ErrUnknownReferrer means no other user owns the referrer
+	ErrUnknownReferrer = errors.New("unknown referrer")
+	// ErrAlreadyRedeemed means the user has already redeemed a referrer
+	ErrAlreadyRedeemed = errors.New("a referrer was already redeemed")
+	// ErrTaskNotFound means no task of the catalog has the code
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrDuplicateTask means another task already has the code
+	ErrDuplicateTask = errors.New("task code is already taken")
+	// ErrTaskInUse means the task can't be deleted, users completed or submitted it
+	ErrTaskInUse = errors.New("task was completed or submitted, deactivate it instead")
+	// ErrTaskInactive means the task can't be completed now, its active window is over or
+	// hasn't started yet
+	ErrTaskInactive = errors.New("task is not active")
+	// ErrTaskCompleted means the user can't complete the task again, it isn't repeatable
+	// or the user reached its maximum number of completions
+	ErrTaskCompleted = errors.New("task was already completed")
+	// ErrTaskCooldown means the user can complete the repeatable task again later, it is
+	// on cooldown or the user reached its daily or weekly limit. The error is a
+	// *CooldownError telling when.
+	ErrTaskCooldown = errors.New("task is on cooldown")
+	// ErrCheckedIn means the user already checked in on the current day, the error is a
+	// *CooldownError telling when the next check-in is possible
+	ErrCheckedIn = errors.New("already checked in today")
+	// ErrTimezoneChanged means the user changed their timezone recently, the error is a
+	// *CooldownError telling when the next change is possible
+	ErrTimezoneChanged = errors.New("timezone was changed recently")
+	// ErrAccountTaken means the account on another platform already verified the task for
+	// another user
+	ErrAccountTaken = errors.New("the account already verified the task for another user")
+	// ErrSubmissionNotFound means no task submission has the id
+	ErrSubmissionNotFound = errors.New("submission not found")
+	// ErrSubmissionPending means a submission of the user for the task is already waiting
+	// for review
+	ErrSubmissionPending = errors.New("a submission of the task is already waiting for review")
+	// ErrSubmissionReviewed means the submission was already approved or rejected
+	ErrSubmissionReviewed = errors.New("submission was already reviewed")
+	// ErrQuestNotFound means no quest has the code
+	ErrQuestNotFound = errors.New("quest not found")
+	// ErrDuplicateQuest means another quest already has the code
+	ErrDuplicateQuest = errors.New("quest code is already taken")
+	// ErrQuestInUse means the quest can't be deleted, users completed it or other quests
+	// require it
+	ErrQuestInUse = errors.New("quest was completed or is required by another quest")
+	// ErrUnknownPrerequisite means a quest the new quest requires doesn't exist
+	ErrUnknownPrerequisite = errors.New("required quest not found")
+)
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdempotencyStore is implemented by the repositories which remember the responses of
+// the requests sent with an Idempotency-Key, so a retried request isn't applied twice
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims key for the user before the request is applied, for the
+	// lease. It returns nil when the key is new, was last used before since or its request
+	// is still without a response after the lease, and the stored record otherwise. The
+	// record has no response while the first request is in flight.
+	ReserveIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, since time.Time, lease time.Duration) (*IdempotencyRecord, error)
+	// CompleteIdempotencyKey stores the response of the request which reserved key
+	CompleteIdempotencyKey(ctx context.Context, userID int, key string, response StoredResponse) error
+	// ReleaseIdempotencyKey forgets a reserved key, so the request can be retried
+	ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error
+	// DeleteIdempotencyKeys deletes the keys reserved before the time and returns how many
+	DeleteIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// StoredResponse is the response replayed to the retries of a request, Header holds the
+// headers the handler set, such as Content-Type and Location
+type StoredResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+// IdempotencyRecord is a reserved idempotency key. Fingerprint identifies the request
+// which reserved it, Response is nil until that request is complete. A request which
+// crashed never completes, another one takes the key over after LockedUntil.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Response    *StoredResponse
+	CreatedAt   time.Time
+	LockedUntil time.Time
+}
+
+// ReserveIdempotencyKey inserts the key, or returns the record already holding it
+func (u *sqlRepository) ReserveIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, since time.Time, lease time.Duration) (record *IdempotencyRecord, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "ReserveIdempotencyKey")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the keys are stored in UTC, an expired one is free again and so is one whose request
+	// didn't complete within its lease
+	now := time.Now().UTC()
+	stmt := `delete from idempotency_keys where user_id = $1 and key = $2
+		and (created_at < $3 or (status_code is null and locked_until < $4))`
+	if _, err := tx.ExecContext(ctx, stmt, userID, key, since.UTC(), now); err != nil {
+		return nil, err
+	}
+
+	stmt = `insert into idempotency_keys (user_id, key, fingerprint, created_at, locked_until) values ($1, $2, $3, $4, $5)
+		on conflict (user_id, key) do nothing`
+	res, err := tx.ExecContext(ctx, stmt, userID, key, fingerprint, now, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if reserved == 0 {
+		record, err = idempotencyRecord(ctx, tx, userID, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// idempotencyRecord reads the record holding the key
+func idempotencyRecord(ctx context.Context, q rowQuerier, userID int, key string) (*IdempotencyRecord, error) {
+	query := `select fingerprint, status_code, response_headers, response_body, created_at, locked_until
+		from idempotency_keys where user_id = $1 and key = $2`
+
+	var record IdempotencyRecord
+	var status sql.NullInt64
+	var header, body string
+	var lockedUntil sql.NullTime
+	err := q.QueryRowContext(ctx, query, userID, key).Scan(&record.Fingerprint, &status, &header, &body, &record.CreatedAt, &lockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("reading idempotency key: %w", err)
+	}
+	record.LockedUntil = lockedUntil.Time
+
+	if status.Valid {
+		record.Response = &StoredResponse{Status: int(status.Int64), Body: []byte(body)}
+		if err := json.Unmarshal([]byte(header), &record.Response.Header); err != nil {
+			return nil, fmt.Errorf("decoding the headers of idempotency key: %w", err)
+		}
+	}
+
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request
+func (u *sqlRepository) CompleteIdempotencyKey(ctx context.Context, userID int, key string, response StoredResponse) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "CompleteIdempotencyKey")
+	defer func() { done(err) }()
+
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	stmt := `update idempotency_keys set status_code = $1, response_headers = $2, response_body = $3
+		where user_id = $4 and key = $5`
+
+	res, err := u.Conn.ExecContext(ctx, stmt, response.Status, string(header), string(response.Body), userID, key)
+	if n, _ := rowsAffected(res, err); err == nil && n == 0 {
+		return errors.New("idempotency key is not reserved")
+	}
+
+	return err
+}
+
+// ReleaseIdempotencyKey deletes the key
+func (u *sqlRepository) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "ReleaseIdempotencyKey")
+	defer func() { done(err) }()
+
+	_, err = u.Conn.ExecContext(ctx, `delete from idempotency_keys where user_id = $1 and key = $2`, userID, key)
+
+	return err
+}
+
+// DeleteIdempotencyKeys deletes the expired keys
+func (u *sqlRepository) DeleteIdempotencyKeys(ctx context.Context, before time.Time) (deleted int64, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "DeleteIdempotencyKeys")
+	defer func() { done(err) }()
+
+	res, err := u.Conn.ExecContext(ctx, `delete from idempotency_keys where created_at < $1`, before.UTC())
+
+	return rowsAffected(res, err)
+}
+
+// rowsAffected returns the number of rows changed by a statement
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
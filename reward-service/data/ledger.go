@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sources of point transactions
+const (
+	SourceInitial  = "initial"
+	SourceTask     = "task"
+	SourceReferral = "referral"
+	SourceAdmin    = "admin"
+)
+
+// ReferenceCustomTask is the reference of the points admins credit for a custom task which
+// isn't in the catalog, their amount is arbitrary
+const ReferenceCustomTask = "custom"
+
+// history page sizes
+const (
+	DefaultHistoryLimit = 20
+	MaxHistoryLimit     = 100
+)
+
+// PointChange tells the ledger why the score of a user changes. ActorID is the user who
+// caused the change, zero when the service or an operator did.
+type PointChange struct {
+	Source    string
+	Reason    string
+	Reference string
+	ActorID   int
+}
+
+// Transaction is one entry of the append-only points ledger, Balance is the score right
+// after the change. The entries outlive the user they belong to.
+type Transaction struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id"`
+	Delta     int       `json:"delta"`
+	Balance   int       `json:"balance"`
+	Source    string    `json:"source"`
+	Reason    string    `json:"reason,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	ActorID   int       `json:"actor_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HistoryPage selects a page of the ledger of a user, newest first. Before is the id of
+// the last transaction of the previous page, zero for the first page.
+type HistoryPage struct {
+	Before int64
+	Limit  int
+}
+
+// limit returns the page size, bounded to MaxHistoryLimit
+func (p HistoryPage) limit() int {
+	if p.Limit <= 0 {
+		return DefaultHistoryLimit
+	}
+
+	return min(p.Limit, MaxHistoryLimit)
+}
+
+// recordTransaction appends one entry to the ledger and advances the quests of the user
+// with it, it runs in the transaction changing the score
+func recordTransaction(ctx context.Context, tx *sql.Tx, userID, delta, balance int, change PointChange) error {
+	stmt := `insert into point_transactions (user_id, delta, balance, source, reason, reference, actor_id, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := tx.ExecContext(ctx, stmt, userID, delta, balance, change.Source, change.Reason, change.Reference, nullID(change.ActorID), time.Now())
+	if err != nil {
+		return fmt.Errorf("recording point transaction: %w", err)
+	}
+
+	return advanceQuests(ctx, tx, userID, delta, change)
+}
+
+// PointHistory returns one page of the ledger of the user, newest first
+func (u *sqlRepository) PointHistory(ctx context.Context, userID int, page HistoryPage) (transactions []Transaction, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "PointHistory")
+	defer func() { done(err) }()
+
+	query := `select id, user_id, delta, balance, source, reason, reference, actor_id, created_at
+		from point_transactions where user_id = $1 and ($2 = 0 or id < $2) order by id desc limit $3`
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		transactions = []Transaction{}
+		rows, err := db.QueryContext(ctx, query, userID, page.Before, page.limit())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t Transaction
+			var actor sql.NullInt64
+			err := rows.Scan(&t.ID, &t.UserID, &t.Delta, &t.Balance, &t.Source, &t.Reason, &t.Reference, &actor, &t.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("scanning point transaction: %w", err)
+			}
+			t.ActorID = int(actor.Int64)
+
+			transactions = append(transactions, t)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
@@ -0,0 +1,1250 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryBcryptCost is deliberately low, the in-memory repository is meant for tests
+const memoryBcryptCost = bcrypt.MinCost
+
+// MemoryRepository is a thread-safe Repository keeping everything in memory. It behaves
+// like PostgresRepository, including the errors it returns, and is used to test the
+// handlers without a database.
+type MemoryRepository struct {
+	mu     sync.RWMutex
+	users  map[int]*User
+	nextID int
+	// referredBy holds the referrer each user redeemed
+	referredBy map[int]string
+	// timezoneChanges holds when each user last changed their timezone
+	timezoneChanges map[int]time.Time
+	// transactions is the ledger, oldest first
+	transactions []Transaction
+	lastTxID     int64
+	// idempotencyKeys are the reserved keys by user and key
+	idempotencyKeys map[idempotencyKey]*IdempotencyRecord
+	// tasks is the task catalog by code
+	tasks      map[string]*Task
+	nextTaskID int
+	// completions are the task completions, oldest first
+	completions      []TaskCompletion
+	lastCompletionID int64
+	// submissions are the task submissions, oldest first
+	submissions      []Submission
+	lastSubmissionID int64
+	// streaks are the streaks of the users who checked in, checkins the check-ins, oldest first
+	streaks       map[int]Streak
+	checkins      []Checkin
+	lastCheckinID int64
+	// quests are the quests by code, questProgress and questCompletions the progress of the
+	// users on them and when they completed them
+	quests           map[string]*Quest
+	nextQuestID      int
+	questProgress    map[questKey][]int
+	questCompletions map[questKey]time.Time
+}
+
+// questKey identifies the progress of a user on a quest
+type questKey struct {
+	userID int
+	code   string
+}
+
+// idempotencyKey identifies a reserved key, keys are scoped to the user sending them
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+// NewMemoryRepository returns an in-memory repository without users and with the DefaultTasks
+func NewMemoryRepository() *MemoryRepository {
+	m := &MemoryRepository{
+		users:            make(map[int]*User),
+		nextID:           1,
+		referredBy:       make(map[int]string),
+		timezoneChanges:  make(map[int]time.Time),
+		idempotencyKeys:  make(map[idempotencyKey]*IdempotencyRecord),
+		tasks:            make(map[string]*Task),
+		nextTaskID:       1,
+		streaks:          make(map[int]Streak),
+		quests:           make(map[string]*Quest),
+		nextQuestID:      1,
+		questProgress:    make(map[questKey][]int),
+		questCompletions: make(map[questKey]time.Time),
+	}
+	// seeded like the tasks migration does
+	for _, task := range DefaultTasks {
+		m.insertTask(task)
+	}
+
+	return m
+}
+
+// publicCopy returns a copy of the user without the password hash, like the
+// Postgres queries which don't select it
+func publicCopy(user *User) *User {
+	c := *user
+	c.Password = ""
+	return &c
+}
+
+// GetAll returns a slice of all users, sorted by score
+func (m *MemoryRepository) GetAll(ctx context.Context) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]*User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, publicCopy(user))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Score != users[j].Score {
+			return users[i].Score > users[j].Score
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	return users, nil
+}
+
+// GetByEmail returns one user by email, including the password hash
+func (m *MemoryRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			c := *user
+			return &c, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// GetOne returns one user by id
+func (m *MemoryRepository) GetOne(ctx context.Context, id int) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return publicCopy(user), nil
+}
+
+// Update updates email, names, active flag and role of the user
+func (m *MemoryRepository) Update(ctx context.Context, user User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	stored.Email = user.Email
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Active = user.Active
+	stored.Role = user.Role
+	stored.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// SetTimezone changes the timezone of the user at most once every the duration
+func (m *MemoryRepository) SetTimezone(ctx context.Context, id int, timezone string, every time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Timezone == timezone {
+		return nil
+	}
+
+	now := time.Now()
+	if changedAt, ok := m.timezoneChanges[id]; ok && now.Before(changedAt.Add(every)) {
+		return &CooldownError{Err: ErrTimezoneChanged, AvailableAt: changedAt.Add(every)}
+	}
+	stored.Timezone = timezone
+	stored.UpdatedAt = now
+	m.timezoneChanges[id] = now
+
+	return nil
+}
+
+// UpdateScore provides whole new score to the user
+func (m *MemoryRepository) UpdateScore(ctx context.Context, user User, change PointChange) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if user.Score < 0 {
+		return ErrInsufficientPoints
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	m.record(user.ID, user.Score-stored.Score, user.Score, change)
+	stored.Score = user.Score
+	stored.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// DeleteByID deletes one user by id
+func (m *MemoryRepository) DeleteByID(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users, id)
+	delete(m.referredBy, id)
+	delete(m.timezoneChanges, id)
+	// the ledger is append-only, the transactions of the user are kept
+	m.completions = slices.DeleteFunc(m.completions, func(c TaskCompletion) bool { return c.UserID == id })
+	m.submissions = slices.DeleteFunc(m.submissions, func(s Submission) bool { return s.UserID == id })
+	delete(m.streaks, id)
+	m.checkins = slices.DeleteFunc(m.checkins, func(c Checkin) bool { return c.UserID == id })
+	for key := range m.questProgress {
+		if key.userID == id {
+			delete(m.questProgress, key)
+		}
+	}
+	for key := range m.questCompletions {
+		if key.userID == id {
+			delete(m.questCompletions, key)
+		}
+	}
+	for i := range m.submissions {
+		if m.submissions[i].ReviewerID == id {
+			m.submissions[i].ReviewerID = 0
+		}
+	}
+	for key := range m.idempotencyKeys {
+		if key.userID == id {
+			delete(m.idempotencyKeys, key)
+		}
+	}
+
+	return nil
+}
+
+// Insert stores a new user with a hashed password and returns its id
+func (m *MemoryRepository) Insert(ctx context.Context, user User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), memoryBcryptCost)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, ErrDuplicateEmail
+	}
+
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	user.Timezone = user.timezone()
+	user.ID = m.nextID
+	user.Password = string(hashedPassword)
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	m.users[user.ID] = &user
+	m.nextID++
+	if user.Score != 0 {
+		m.record(user.ID, user.Score, user.Score, PointChange{Source: SourceInitial, Reason: "initial score"})
+	}
+
+	return user.ID, nil
+}
+
+// emailTaken reports whether a user other than exceptID uses email, the caller holds the lock
+func (m *MemoryRepository) emailTaken(email string, exceptID int) bool {
+	for id, user := range m.users {
+		if id != exceptID && user.Email == email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResetPassword sets a new password for the user
+func (m *MemoryRepository) ResetPassword(ctx context.Context, password string, user User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), memoryBcryptCost)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Password = string(hashedPassword)
+
+	return nil
+}
+
+// PasswordMatches compares a user supplied password with the stored hash
+func (m *MemoryRepository) PasswordMatches(plainText string, user User) (bool, error) {
+	return passwordMatches(plainText, user)
+}
+
+// AddPoints adds some points to the user and records the change in the ledger
+func (m *MemoryRepository) AddPoints(ctx context.Context, id, point int, change PointChange) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Score+point < 0 {
+		return ErrInsufficientPoints
+	}
+	stored.Score += point
+	stored.UpdatedAt = time.Now()
+	m.record(id, point, stored.Score, change)
+
+	return nil
+}
+
+// RedeemReferrer adds the referrer bonus to the other owners of referrer and the referee bonus
+// to id, once per user
+func (m *MemoryRepository) RedeemReferrer(ctx context.Context, id int, referrer string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, redeemed := m.referredBy[id]; redeemed {
+		return ErrAlreadyRedeemed
+	}
+
+	var owners []*User
+	for ownerID, owner := range m.users {
+		if ownerID != id && owner.Referrer == referrer {
+			owners = append(owners, owner)
+		}
+	}
+	if len(owners) == 0 {
+		return ErrUnknownReferrer
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].ID < owners[j].ID })
+
+	change := PointChange{Source: SourceReferral, Reason: "referrer redeemed", Reference: referrer, ActorID: id}
+	user.Score += RefereeBonus
+	m.record(id, RefereeBonus, user.Score, change)
+	for _, owner := range owners {
+		owner.Score += ReferrerBonus
+		m.record(owner.ID, ReferrerBonus, owner.Score, change)
+	}
+	m.referredBy[id] = referrer
+
+	return nil
+}
+
+// record appends a transaction to the ledger, the caller holds the lock
+func (m *MemoryRepository) record(userID, delta, balance int, change PointChange) {
+	m.lastTxID++
+	m.transactions = append(m.transactions, Transaction{
+		ID:        m.lastTxID,
+		UserID:    userID,
+		Delta:     delta,
+		Balance:   balance,
+		Source:    change.Source,
+		Reason:    change.Reason,
+		Reference: change.Reference,
+		ActorID:   change.ActorID,
+		CreatedAt: time.Now(),
+	})
+	m.advanceQuests(userID, delta, change)
+}
+
+// PointHistory returns one page of the ledger of the user, newest first
+func (m *MemoryRepository) PointHistory(ctx context.Context, userID int, page HistoryPage) ([]Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	transactions := []Transaction{}
+	for i := len(m.transactions) - 1; i >= 0 && len(transactions) < page.limit(); i-- {
+		t := m.transactions[i]
+		if t.UserID == userID && (page.Before == 0 || t.ID < page.Before) {
+			transactions = append(transactions, t)
+		}
+	}
+
+	return transactions, nil
+}
+
+// ReserveIdempotencyKey claims the key, or returns a copy of the record already holding it
+func (m *MemoryRepository) ReserveIdempotencyKey(ctx context.Context, userID int, key, fingerprint string, since time.Time, lease time.Duration) (*IdempotencyRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := idempotencyKey{userID: userID, key: key}
+	now := time.Now()
+	if record, ok := m.idempotencyKeys[id]; ok && !record.CreatedAt.Before(since) && (record.Response != nil || !record.LockedUntil.Before(now)) {
+		c := *record
+		return &c, nil
+	}
+	m.idempotencyKeys[id] = &IdempotencyRecord{Fingerprint: fingerprint, CreatedAt: now, LockedUntil: now.Add(lease)}
+
+	return nil, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request
+func (m *MemoryRepository) CompleteIdempotencyKey(ctx context.Context, userID int, key string, response StoredResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.idempotencyKeys[idempotencyKey{userID: userID, key: key}]
+	if !ok {
+		return errors.New("idempotency key is not reserved")
+	}
+	response.Body = append([]byte(nil), response.Body...)
+	response.Header = maps.Clone(response.Header)
+	record.Response = &response
+
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes the key
+func (m *MemoryRepository) ReleaseIdempotencyKey(ctx context.Context, userID int, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotencyKeys, idempotencyKey{userID: userID, key: key})
+
+	return nil
+}
+
+// DeleteIdempotencyKeys deletes the expired keys
+func (m *MemoryRepository) DeleteIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for id, record := range m.idempotencyKeys {
+		if record.CreatedAt.Before(before) {
+			delete(m.idempotencyKeys, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// ListTasks returns the whole catalog, sorted by code
+func (m *MemoryRepository) ListTasks(ctx context.Context) ([]Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tasks := make([]Task, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		tasks = append(tasks, *task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Code < tasks[j].Code })
+
+	return tasks, nil
+}
+
+// GetTask returns a copy of the task with the code
+func (m *MemoryRepository) GetTask(ctx context.Context, code string) (*Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	task, ok := m.tasks[code]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	c := *task
+
+	return &c, nil
+}
+
+// InsertTask adds a task to the catalog and returns its id
+func (m *MemoryRepository) InsertTask(ctx context.Context, task Task) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tasks[task.Code]; ok {
+		return 0, ErrDuplicateTask
+	}
+
+	return m.insertTask(task), nil
+}
+
+// insertTask stores the task under a new id, the caller holds the lock
+func (m *MemoryRepository) insertTask(task Task) int {
+	task.ID = m.nextTaskID
+	m.nextTaskID++
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	m.tasks[task.Code] = &task
+
+	return task.ID
+}
+
+// UpdateTask replaces every field of the task with the code, except the code itself
+func (m *MemoryRepository) UpdateTask(ctx context.Context, task Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.tasks[task.Code]
+	if !ok {
+		return ErrTaskNotFound
+	}
+	task.ID = stored.ID
+	task.CreatedAt = stored.CreatedAt
+	task.UpdatedAt = time.Now()
+	m.tasks[task.Code] = &task
+
+	return nil
+}
+
+// DeleteTask removes the task with the code from the catalog unless a user completed or
+// submitted it
+func (m *MemoryRepository) DeleteTask(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tasks[code]; !ok {
+		return ErrTaskNotFound
+	}
+	used := slices.ContainsFunc(m.completions, func(c TaskCompletion) bool { return c.TaskCode == code }) ||
+		slices.ContainsFunc(m.submissions, func(s Submission) bool { return s.TaskCode == code })
+	if used {
+		return ErrTaskInUse
+	}
+	delete(m.tasks, code)
+
+	return nil
+}
+
+// CompleteTask records the completion of the task with the code and awards its points,
+// a pending completion of the user becomes completed
+func (m *MemoryRepository) CompleteTask(ctx context.Context, userID int, code string, change PointChange) (*TaskCompletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.completeTask(userID, code, change)
+}
+
+// completeTask records the completion and awards its points, the caller holds the lock
+func (m *MemoryRepository) completeTask(userID int, code string, change PointChange) (*TaskCompletion, error) {
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	task, ok := m.tasks[code]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	now := time.Now()
+	history, pending := m.historyOf(user, code, now)
+	if err := task.CanComplete(history, now); err != nil {
+		return nil, err
+	}
+
+	completion := TaskCompletion{UserID: userID, TaskCode: code, Points: task.Points, Status: CompletionCompleted, CompletedAt: now}
+	if pending != nil {
+		completion.ID, completion.Account = pending.ID, pending.Account
+		*pending = completion
+	} else {
+		m.lastCompletionID++
+		completion.ID = m.lastCompletionID
+		m.completions = append(m.completions, completion)
+	}
+	user.Score += task.Points
+	user.UpdatedAt = now
+	change.Reference = code
+	m.record(userID, task.Points, user.Score, change)
+
+	return &completion, nil
+}
+
+// SetTaskPending records a completion of the task waiting for its verification
+func (m *MemoryRepository) SetTaskPending(ctx context.Context, userID int, code, account string) (*TaskCompletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	task, ok := m.tasks[code]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	now := time.Now()
+	history, pending := m.historyOf(user, code, now)
+	if err := task.CanComplete(history, now); err != nil {
+		return nil, err
+	}
+	for _, c := range m.completions {
+		if account != "" && c.TaskCode == code && c.Account == account && c.UserID != userID {
+			return nil, ErrAccountTaken
+		}
+	}
+
+	if pending == nil {
+		m.lastCompletionID++
+		m.completions = append(m.completions, TaskCompletion{ID: m.lastCompletionID, UserID: userID, TaskCode: code, Points: task.Points, Status: CompletionPending})
+		pending = &m.completions[len(m.completions)-1]
+	}
+	pending.Account = account
+	pending.CompletedAt = now
+	pending.attemptedAt = now
+	c := *pending
+
+	return &c, nil
+}
+
+// PendingTasks returns up to limit pending completions, the ones attempted least recently
+// first, and marks them attempted now
+func (m *MemoryRepository) PendingTasks(ctx context.Context, limit int) ([]TaskCompletion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []*TaskCompletion
+	for i := range m.completions {
+		if m.completions[i].Status == CompletionPending {
+			pending = append(pending, &m.completions[i])
+		}
+	}
+	// the completions are sorted by id already
+	slices.SortStableFunc(pending, func(a, b *TaskCompletion) int {
+		return a.attemptedAt.Compare(b.attemptedAt)
+	})
+
+	now := time.Now()
+	completions := []TaskCompletion{}
+	for _, c := range pending[:min(limit, len(pending))] {
+		c.attemptedAt = now
+		completions = append(completions, *c)
+	}
+
+	return completions, nil
+}
+
+// CancelPendingTask deletes the pending completion of the task by the user, if any
+func (m *MemoryRepository) CancelPendingTask(ctx context.Context, userID int, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.completions = slices.DeleteFunc(m.completions, func(c TaskCompletion) bool {
+		return c.UserID == userID && c.TaskCode == code && c.Status == CompletionPending
+	})
+
+	return nil
+}
+
+// historyOf returns the completion history of the task by the user at the time and their
+// pending completion of it, the caller holds the lock
+func (m *MemoryRepository) historyOf(user *User, code string, now time.Time) (CompletionHistory, *TaskCompletion) {
+	history := CompletionHistory{Periods: PeriodsAt(now, user.Location())}
+	var pending *TaskCompletion
+	for i, c := range m.completions {
+		if c.UserID != user.ID || c.TaskCode != code {
+			continue
+		}
+		if c.Status == CompletionPending {
+			pending = &m.completions[i]
+		} else {
+			history.add(c.CompletedAt)
+		}
+	}
+
+	return history, pending
+}
+
+// UserTasks returns every task of the catalog with the completions of the user, sorted by
+// code. The limits of the tasks are evaluated now, in the timezone of the user.
+func (m *MemoryRepository) UserTasks(ctx context.Context, userID int) ([]UserTask, error) {
+	tasks, err := m.ListTasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	now := time.Now()
+	periods := PeriodsAt(now, user.Location())
+
+	userTasks := make([]UserTask, 0, len(tasks))
+	for _, task := range tasks {
+		userTask := newUserTask(task, periods)
+		for _, c := range m.completions {
+			if c.UserID == userID && c.TaskCode == task.Code {
+				userTask.count(c.Status, c.CompletedAt)
+			}
+		}
+		userTask.settle(now)
+		userTasks = append(userTasks, userTask)
+	}
+
+	return userTasks, nil
+}
+
+// SubmitTask queues the submission for review
+func (m *MemoryRepository) SubmitTask(ctx context.Context, submission Submission) (*Submission, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[submission.UserID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	task, ok := m.tasks[submission.TaskCode]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	now := time.Now()
+	history, _ := m.historyOf(user, submission.TaskCode, now)
+	if err := task.CanComplete(history, now); err != nil {
+		return nil, err
+	}
+	for _, s := range m.submissions {
+		if s.UserID == submission.UserID && s.TaskCode == submission.TaskCode && s.Status == SubmissionPending {
+			return nil, ErrSubmissionPending
+		}
+	}
+
+	m.lastSubmissionID++
+	queued := Submission{
+		ID:        m.lastSubmissionID,
+		UserID:    submission.UserID,
+		TaskCode:  submission.TaskCode,
+		Proof:     submission.Proof,
+		ImageKey:  submission.ImageKey,
+		Status:    SubmissionPending,
+		CreatedAt: now,
+	}
+	m.submissions = append(m.submissions, queued)
+
+	return &queued, nil
+}
+
+// GetSubmission returns a copy of the submission with the id
+func (m *MemoryRepository) GetSubmission(ctx context.Context, id int64) (*Submission, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	submission := m.submission(id)
+	if submission == nil {
+		return nil, ErrSubmissionNotFound
+	}
+	s := *submission
+
+	return &s, nil
+}
+
+// ListSubmissions returns one page of the submissions matching the filter, newest first
+func (m *MemoryRepository) ListSubmissions(ctx context.Context, filter SubmissionFilter, page HistoryPage) ([]Submission, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	submissions := []Submission{}
+	for i := len(m.submissions) - 1; i >= 0 && len(submissions) < page.limit(); i-- {
+		s := m.submissions[i]
+		if (page.Before == 0 || s.ID < page.Before) && filter.matches(&s) {
+			submissions = append(submissions, s)
+		}
+	}
+
+	return submissions, nil
+}
+
+// ApproveSubmission completes the task of the pending submission and awards its points
+func (m *MemoryRepository) ApproveSubmission(ctx context.Context, id int64, change PointChange) (*Submission, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	submission, err := m.pendingSubmission(id)
+	if err != nil {
+		return nil, err
+	}
+	completion, err := m.completeTask(submission.UserID, submission.TaskCode, change)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	submission.Status = SubmissionApproved
+	submission.Points = completion.Points
+	submission.ReviewerID = change.ActorID
+	submission.ReviewedAt = &now
+	s := *submission
+
+	return &s, nil
+}
+
+// RejectSubmission closes the pending submission with the reason
+func (m *MemoryRepository) RejectSubmission(ctx context.Context, id int64, reviewerID int, reason string) (*Submission, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	submission, err := m.pendingSubmission(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	submission.Status = SubmissionRejected
+	submission.Reason = reason
+	submission.ReviewerID = reviewerID
+	submission.ReviewedAt = &now
+	s := *submission
+
+	return &s, nil
+}
+
+// submission returns the stored submission with the id, nil without one. The caller holds the lock.
+func (m *MemoryRepository) submission(id int64) *Submission {
+	for i := range m.submissions {
+		if m.submissions[i].ID == id {
+			return &m.submissions[i]
+		}
+	}
+
+	return nil
+}
+
+// pendingSubmission returns the stored submission with the id when it is still pending,
+// the caller holds the lock
+func (m *MemoryRepository) pendingSubmission(id int64) (*Submission, error) {
+	submission := m.submission(id)
+	if submission == nil {
+		return nil, ErrSubmissionNotFound
+	}
+	if submission.Status != SubmissionPending {
+		return nil, ErrSubmissionReviewed
+	}
+
+	return submission, nil
+}
+
+// CheckIn records the check-in of the user on their current day and awards its points
+func (m *MemoryRepository) CheckIn(ctx context.Context, userID int, rules CheckinRules, change PointChange) (*Checkin, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	checkin, next, err := rules.next(m.streaks[userID], time.Now(), user.Location())
+	if err != nil {
+		return nil, err
+	}
+
+	m.lastCheckinID++
+	checkin.ID = m.lastCheckinID
+	checkin.UserID = userID
+	m.checkins = append(m.checkins, *checkin)
+	m.streaks[userID] = next
+
+	user.Score += checkin.Points
+	user.UpdatedAt = time.Now()
+	change.Reference = checkin.Day
+	m.record(userID, checkin.Points, user.Score, change)
+
+	return checkin, nil
+}
+
+// Streak returns the streak of the user, its current streak is zero once it is broken
+func (m *MemoryRepository) Streak(ctx context.Context, userID int, rules CheckinRules) (*Streak, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	streak := rules.current(m.streaks[userID], time.Now(), user.Location())
+
+	return &streak, nil
+}
+
+// GrantStreakFreezes adds count streak freezes to the user
+func (m *MemoryRepository) GrantStreakFreezes(ctx context.Context, userID, count int) (*Streak, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return nil, ErrNotFound
+	}
+	streak := m.streaks[userID]
+	streak.Freezes += count
+	m.streaks[userID] = streak
+
+	return &streak, nil
+}
+
+// ListQuests returns every quest sorted by code
+func (m *MemoryRepository) ListQuests(ctx context.Context) ([]Quest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedQuests(), nil
+}
+
+// GetQuest returns a copy of the quest with the code
+func (m *MemoryRepository) GetQuest(ctx context.Context, code string) (*Quest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	quest, ok := m.quests[code]
+	if !ok {
+		return nil, ErrQuestNotFound
+	}
+	c := copyQuest(quest)
+
+	return &c, nil
+}
+
+// InsertQuest adds the quest and returns its id, every quest it requires must exist
+func (m *MemoryRepository) InsertQuest(ctx context.Context, quest Quest) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.quests[quest.Code]; ok {
+		return 0, ErrDuplicateQuest
+	}
+	requires := []string{}
+	for _, code := range quest.Requires {
+		if _, ok := m.quests[code]; !ok {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownPrerequisite, code)
+		}
+		if !slices.Contains(requires, code) {
+			requires = append(requires, code)
+		}
+	}
+	slices.Sort(requires)
+
+	quest = copyQuest(&quest)
+	quest.Requires = requires
+	quest.ID = m.nextQuestID
+	m.nextQuestID++
+	quest.CreatedAt = time.Now()
+	m.quests[quest.Code] = &quest
+
+	return quest.ID, nil
+}
+
+// DeleteQuest removes the quest with the progress of the users on it, unless a user
+// completed it or another quest requires it
+func (m *MemoryRepository) DeleteQuest(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.quests[code]; !ok {
+		return ErrQuestNotFound
+	}
+	for key := range m.questCompletions {
+		if key.code == code {
+			return ErrQuestInUse
+		}
+	}
+	for _, quest := range m.quests {
+		if slices.Contains(quest.Requires, code) {
+			return ErrQuestInUse
+		}
+	}
+	delete(m.quests, code)
+	for key := range m.questProgress {
+		if key.code == code {
+			delete(m.questProgress, key)
+		}
+	}
+
+	return nil
+}
+
+// UserQuests returns every quest with the progress of the user, sorted by code
+func (m *MemoryRepository) UserQuests(ctx context.Context, userID int) ([]UserQuest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return nil, ErrNotFound
+	}
+	state := m.questState(userID)
+	quests := m.sortedQuests()
+	userQuests := make([]UserQuest, 0, len(quests))
+	for _, quest := range quests {
+		userQuests = append(userQuests, state.userQuest(quest))
+	}
+
+	return userQuests, nil
+}
+
+// advanceQuests applies the ledger entry of the user to their unlocked quests and awards
+// the bonus of every quest it completes, the caller holds the lock
+func (m *MemoryRepository) advanceQuests(userID, delta int, change PointChange) {
+	user, ok := m.users[userID]
+	if !questEvent(change) || !ok {
+		return
+	}
+
+	// the quests completed here unlock others only from the next entry on
+	completed := make(map[string]bool)
+	for _, code := range slices.Sorted(maps.Keys(m.quests)) {
+		quest := m.quests[code]
+		key := questKey{userID: userID, code: code}
+		if _, ok := m.questCompletions[key]; ok || !m.questUnlocked(userID, quest, completed) {
+			continue
+		}
+		progress, changed := quest.advance(m.questProgress[key], userID, delta, change)
+		if !changed {
+			continue
+		}
+
+		m.questProgress[key] = progress
+		if !quest.done(progress) {
+			continue
+		}
+
+		m.questCompletions[key] = time.Now()
+		completed[code] = true
+		user.Score += quest.Bonus
+		user.UpdatedAt = time.Now()
+		m.record(userID, quest.Bonus, user.Score, PointChange{Source: SourceQuest, Reason: "quest completed", Reference: quest.Code})
+	}
+}
+
+// questUnlocked reports whether the user completed every quest the quest requires, other
+// than the ones in skip, the caller holds the lock
+func (m *MemoryRepository) questUnlocked(userID int, quest *Quest, skip map[string]bool) bool {
+	for _, code := range quest.Requires {
+		if _, ok := m.questCompletions[questKey{userID: userID, code: code}]; !ok || skip[code] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// questState returns the progress of the user on the quests, the caller holds the lock
+func (m *MemoryRepository) questState(userID int) questState {
+	state := questState{progress: make(map[string][]int), completed: make(map[string]time.Time)}
+	for key, progress := range m.questProgress {
+		if key.userID == userID {
+			state.progress[key.code] = progress
+		}
+	}
+	for key, completedAt := range m.questCompletions {
+		if key.userID == userID {
+			state.completed[key.code] = completedAt
+		}
+	}
+
+	return state
+}
+
+// sortedQuests returns copies of every quest sorted by code, the caller holds the lock
+func (m *MemoryRepository) sortedQuests() []Quest {
+	quests := make([]Quest, 0, len(m.quests))
+	for _, quest := range m.quests {
+		quests = append(quests, copyQuest(quest))
+	}
+	sort.Slice(quests, func(i, j int) bool { return quests[i].Code < quests[j].Code })
+
+	return quests
+}
+
+// copyQuest returns a copy of the quest which shares no slice with it
+func copyQuest(quest *Quest) Quest {
+	c := *quest
+	c.Requires = append([]string{}, quest.Requires...)
+	c.Steps = append([]QuestStep{}, quest.Steps...)
+
+	return c
+}
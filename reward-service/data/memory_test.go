@@ -0,0 +1,9 @@
+package data
+
+import "testing"
+
+func TestMemoryRepository(t *testing.T) {
+	testRepositoryContract(t, func(t *testing.T) Repository {
+		return NewMemoryRepository()
+	})
+}
@@ -0,0 +1,138 @@
+package data
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/pgx"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// every backend has its own migrations, with the same versions
+//
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrations embed.FS
+
+// migrationsDir returns the directory of the migrations of backend
+func migrationsDir(backend string) string {
+	return "migrations/" + backend
+}
+
+// Migrator applies the SQL migrations embedded in the binary
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+// NewMigrator opens its own connection to the database behind dsn, so closing the
+// migrator never closes the pool used to serve requests
+func NewMigrator(dsn string) (*Migrator, error) {
+	backend := BackendOf(dsn)
+	source, err := iofs.New(migrations, migrationsDir(backend))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := migrationDriver(backend, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, backend, driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	return &Migrator{m: m}, nil
+}
+
+// migrationDriver wraps conn into the migrate driver of backend
+func migrationDriver(backend string, conn *sql.DB) (database.Driver, error) {
+	if backend == BackendSQLite {
+		return sqlite.WithInstance(conn, &sqlite.Config{})
+	}
+
+	return pgx.WithInstance(conn, &pgx.Config{})
+}
+
+// Up applies all pending migrations
+func (mg *Migrator) Up() error {
+	return ignoreNoChange(mg.m.Up())
+}
+
+// Down rolls back the given number of migrations
+func (mg *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("steps must be positive, got %d", steps)
+	}
+
+	return ignoreNoChange(mg.m.Steps(-steps))
+}
+
+// Version returns the applied migration version and whether it is dirty,
+// version 0 means no migration was applied yet
+func (mg *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// Force sets the migration version without running any migration, it is used
+// to recover from a dirty state after fixing the database by hand
+func (mg *Migrator) Force(version int) error {
+	return mg.m.Force(version)
+}
+
+// Close releases the connection of the migrator
+func (mg *Migrator) Close() error {
+	sourceErr, dbErr := mg.m.Close()
+	return errors.Join(sourceErr, dbErr)
+}
+
+// LatestMigration returns the version of the newest embedded migration of backend
+func LatestMigration(backend string) (uint, error) {
+	entries, err := fs.ReadDir(migrations, migrationsDir(backend))
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint64
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			continue
+		}
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		latest = max(latest, version)
+	}
+
+	return uint(latest), nil
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+
+	return err
+}
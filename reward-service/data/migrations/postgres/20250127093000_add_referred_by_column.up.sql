@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referred_by VARCHAR(255);
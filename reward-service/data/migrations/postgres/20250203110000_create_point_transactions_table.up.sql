@@ -0,0 +1,14 @@
+-- the ledger is append-only, the transactions of a deleted user are kept
+CREATE TABLE IF NOT EXISTS point_transactions(
+                       id BIGSERIAL PRIMARY KEY,
+                       user_id INT NOT NULL,
+                       delta INT NOT NULL,
+                       balance INT NOT NULL,
+                       source VARCHAR(50) NOT NULL,
+                       reason VARCHAR(255) NOT NULL DEFAULT '',
+                       reference VARCHAR(255) NOT NULL DEFAULT '',
+                       actor_id INT,
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS point_transactions_user_id_idx ON point_transactions (user_id, id DESC);
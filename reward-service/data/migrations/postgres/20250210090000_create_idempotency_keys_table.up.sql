@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+                       user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       key VARCHAR(255) NOT NULL,
+                       fingerprint VARCHAR(255) NOT NULL,
+                       status_code INT,
+                       -- the headers of the stored response as a JSON object
+                       response_headers TEXT NOT NULL DEFAULT '',
+                       response_body TEXT NOT NULL DEFAULT '',
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                       -- a reservation whose request never finished is taken over after its lease
+                       locked_until TIMESTAMP,
+                       PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
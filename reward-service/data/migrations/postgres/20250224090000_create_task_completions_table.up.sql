@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS task_completions(
+                       id BIGSERIAL PRIMARY KEY,
+                       user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       task_id INT NOT NULL REFERENCES tasks(id),
+                       points INT NOT NULL,
+                       repeatable BOOLEAN NOT NULL DEFAULT FALSE,
+                       completed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS task_completions_user_id_idx ON task_completions (user_id, task_id);
+
+-- a task which isn't repeatable is completed once per user
+CREATE UNIQUE INDEX IF NOT EXISTS task_completions_once_idx ON task_completions (user_id, task_id) WHERE NOT repeatable;
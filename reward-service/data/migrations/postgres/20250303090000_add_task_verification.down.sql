@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS task_completions_task_account_idx;
+DROP INDEX IF EXISTS task_completions_account_idx;
+DROP INDEX IF EXISTS task_completions_attempted_idx;
+DROP INDEX IF EXISTS task_completions_status_idx;
+DELETE FROM task_completions WHERE status <> 'completed';
+ALTER TABLE task_completions DROP COLUMN IF EXISTS attempted_at;
+ALTER TABLE task_completions DROP COLUMN IF EXISTS account;
+ALTER TABLE task_completions DROP COLUMN IF EXISTS status;
+ALTER TABLE tasks DROP COLUMN IF EXISTS verifier;
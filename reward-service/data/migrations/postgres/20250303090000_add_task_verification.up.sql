@@ -0,0 +1,16 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS verifier VARCHAR(50) NOT NULL DEFAULT '';
+UPDATE tasks SET verifier = code WHERE code IN ('telegram', 'x');
+
+ALTER TABLE task_completions ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'completed';
+ALTER TABLE task_completions ADD COLUMN IF NOT EXISTS account VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS task_completions_status_idx ON task_completions (status, id);
+
+-- the verification worker retries the pending completions attempted least recently first,
+-- so the ones failing again don't hold back the newer ones
+ALTER TABLE task_completions ADD COLUMN IF NOT EXISTS attempted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS task_completions_attempted_idx ON task_completions (status, attempted_at, id);
+
+-- an account on another platform verifies a task for one user only, a repeatable task is
+-- checked by the repository
+CREATE UNIQUE INDEX IF NOT EXISTS task_completions_account_idx ON task_completions (task_id, account) WHERE account <> '' AND NOT repeatable;
+CREATE INDEX IF NOT EXISTS task_completions_task_account_idx ON task_completions (task_id, account) WHERE account <> '';
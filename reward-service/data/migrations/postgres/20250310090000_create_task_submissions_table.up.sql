@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS task_submissions(
+                       id BIGSERIAL PRIMARY KEY,
+                       user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       task_id INT NOT NULL REFERENCES tasks(id),
+                       proof TEXT NOT NULL,
+                       image_key VARCHAR(255) NOT NULL DEFAULT '',
+                       status VARCHAR(20) NOT NULL DEFAULT 'pending',
+                       reason TEXT NOT NULL DEFAULT '',
+                       points INT NOT NULL DEFAULT 0,
+                       reviewer_id INT NULL REFERENCES users(id) ON DELETE SET NULL,
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                       reviewed_at TIMESTAMP NULL
+);
+
+CREATE INDEX IF NOT EXISTS task_submissions_status_idx ON task_submissions (status, id);
+CREATE INDEX IF NOT EXISTS task_submissions_user_id_idx ON task_submissions (user_id, id);
+
+-- a user has at most one submission of a task waiting for review
+CREATE UNIQUE INDEX IF NOT EXISTS task_submissions_pending_idx ON task_submissions (user_id, task_id) WHERE status = 'pending';
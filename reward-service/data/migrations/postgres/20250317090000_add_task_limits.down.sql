@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS task_completions_completed_at_idx;
+ALTER TABLE tasks DROP COLUMN IF EXISTS weekly_limit;
+ALTER TABLE tasks DROP COLUMN IF EXISTS daily_limit;
+ALTER TABLE tasks DROP COLUMN IF EXISTS cooldown_seconds;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS checkins;
+DROP TABLE IF EXISTS streaks;
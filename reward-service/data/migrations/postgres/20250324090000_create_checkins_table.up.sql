@@ -0,0 +1,23 @@
+-- the streak of daily check-ins of a user, last_day is the calendar day of the last
+-- check-in in the timezone of the user
+CREATE TABLE IF NOT EXISTS streaks(
+                       user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+                       current_streak INT NOT NULL DEFAULT 0,
+                       longest_streak INT NOT NULL DEFAULT 0,
+                       freezes INT NOT NULL DEFAULT 0,
+                       last_day VARCHAR(10) NOT NULL DEFAULT '',
+                       updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS checkins(
+                       id BIGSERIAL PRIMARY KEY,
+                       user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       day VARCHAR(10) NOT NULL,
+                       streak INT NOT NULL,
+                       points INT NOT NULL,
+                       freezes_used INT NOT NULL DEFAULT 0,
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- a user checks in once per calendar day
+CREATE UNIQUE INDEX IF NOT EXISTS checkins_user_id_day_idx ON checkins (user_id, day);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS quest_completions;
+DROP TABLE IF EXISTS quest_progress;
+DROP TABLE IF EXISTS quest_prerequisites;
+DROP TABLE IF EXISTS quest_steps;
+DROP TABLE IF EXISTS quests;
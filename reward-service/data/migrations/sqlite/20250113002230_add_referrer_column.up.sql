@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN referrer VARCHAR(255);
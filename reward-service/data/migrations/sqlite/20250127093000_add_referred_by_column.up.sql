@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN referred_by VARCHAR(255);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS tasks(
+                       id INTEGER PRIMARY KEY AUTOINCREMENT,
+                       code VARCHAR(64) UNIQUE NOT NULL,
+                       title VARCHAR(255) NOT NULL,
+                       description TEXT NOT NULL DEFAULT '',
+                       points INT NOT NULL,
+                       starts_at TIMESTAMP,
+                       ends_at TIMESTAMP,
+                       repeatable BOOLEAN NOT NULL DEFAULT FALSE,
+                       max_completions INT NOT NULL DEFAULT 0,
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                       updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the tasks which had their own handlers, see data.DefaultTasks
+INSERT INTO tasks (code, title, points) VALUES
+    ('telegram', 'Subscribe to the Telegram channel', 50),
+    ('x', 'Follow the X account', 75)
+ON CONFLICT (code) DO NOTHING;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS task_completions_completed_at_idx;
+ALTER TABLE tasks DROP COLUMN weekly_limit;
+ALTER TABLE tasks DROP COLUMN daily_limit;
+ALTER TABLE tasks DROP COLUMN cooldown_seconds;
+ALTER TABLE users DROP COLUMN timezone_changed_at;
+ALTER TABLE users DROP COLUMN timezone;
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+-- changing the timezone starts a new day of the task limits, so it is rate limited
+ALTER TABLE users ADD COLUMN timezone_changed_at TIMESTAMP;
+
+ALTER TABLE tasks ADD COLUMN cooldown_seconds INT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN daily_limit INT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN weekly_limit INT NOT NULL DEFAULT 0;
+
+-- the limits count the recent completions of a user
+CREATE INDEX IF NOT EXISTS task_completions_completed_at_idx ON task_completions (user_id, task_id, completed_at);
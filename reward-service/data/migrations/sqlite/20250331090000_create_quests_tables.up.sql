@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS quests(
+                       id INTEGER PRIMARY KEY AUTOINCREMENT,
+                       code VARCHAR(64) UNIQUE NOT NULL,
+                       title VARCHAR(255) NOT NULL,
+                       description TEXT NOT NULL DEFAULT '',
+                       bonus INT NOT NULL,
+                       ordered BOOLEAN NOT NULL DEFAULT FALSE,
+                       created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- the steps of a quest in their order, a step is done after target_count matching events
+CREATE TABLE IF NOT EXISTS quest_steps(
+                       quest_id INT NOT NULL REFERENCES quests(id) ON DELETE CASCADE,
+                       step INT NOT NULL,
+                       event VARCHAR(20) NOT NULL,
+                       target VARCHAR(64) NOT NULL DEFAULT '',
+                       target_count INT NOT NULL,
+                       PRIMARY KEY (quest_id, step)
+);
+
+-- a quest is unlocked once the user completed every quest it requires, a required quest
+-- can't be deleted
+CREATE TABLE IF NOT EXISTS quest_prerequisites(
+                       quest_id INT NOT NULL REFERENCES quests(id) ON DELETE CASCADE,
+                       required_id INT NOT NULL REFERENCES quests(id),
+                       PRIMARY KEY (quest_id, required_id)
+);
+
+CREATE TABLE IF NOT EXISTS quest_progress(
+                       user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       quest_id INT NOT NULL REFERENCES quests(id) ON DELETE CASCADE,
+                       step INT NOT NULL,
+                       progress INT NOT NULL,
+                       PRIMARY KEY (user_id, quest_id, step)
+);
+
+-- the bonus of a quest is awarded once per user, a completed quest can't be deleted
+CREATE TABLE IF NOT EXISTS quest_completions(
+                       user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                       quest_id INT NOT NULL REFERENCES quests(id),
+                       bonus INT NOT NULL,
+                       completed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                       PRIMARY KEY (user_id, quest_id)
+);
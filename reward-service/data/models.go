@@ -5,27 +5,121 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"slices"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const dbTimeout = time.Second * 3
 
-var db *sql.DB
+// bcryptCost is the cost of the password hashes when the options don't set one
+const bcryptCost = 12
 
-type PostgresRepository struct {
+// points awarded when a referrer is redeemed, to its owner and to the user who redeemed it
+const (
+	ReferrerBonus = 100
+	RefereeBonus  = 25
+)
+
+// sqlRepository implements Repository on database/sql, the queries are written so
+// Postgres and SQLite both run them unchanged
+type sqlRepository struct {
 	Conn *sql.DB
+	// Timeout bounds every single query on top of the caller's context,
+	// the constructors default it to dbTimeout
+	Timeout time.Duration
+	// BcryptCost is the cost of the password hashes, the constructors default it to bcryptCost
+	BcryptCost int
+	// Observer, when set, is notified about every query the repository runs
+	Observer QueryObserver
+
+	replica *replica
+	// isUniqueViolation reports whether err is the unique constraint error of the database
+	isUniqueViolation func(err error) bool
+}
+
+// PostgresRepository is the Repository on a Postgres database
+type PostgresRepository struct {
+	sqlRepository
+}
+
+// PoolOptions tunes the connection pool of a repository, zero values keep the
+// database/sql defaults
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout bounds every single query, dbTimeout is used when it is zero
+	QueryTimeout time.Duration
+	// BcryptCost is the cost of the password hashes, bcryptCost is used when it is zero
+	BcryptCost int
+}
+
+// Apply sets the options on pool
+func (o PoolOptions) Apply(pool *sql.DB) {
+	if o.MaxOpenConns > 0 {
+		pool.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	if o.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(o.MaxIdleConns)
+	}
+	if o.ConnMaxLifetime > 0 {
+		pool.SetConnMaxLifetime(o.ConnMaxLifetime)
+	}
+	if o.ConnMaxIdleTime > 0 {
+		pool.SetConnMaxIdleTime(o.ConnMaxIdleTime)
+	}
 }
 
 func NewPostgresRepository(pool *sql.DB) *PostgresRepository {
-	db = pool
 	return &PostgresRepository{
-		Conn: pool,
+		sqlRepository: sqlRepository{
+			Conn:              pool,
+			Timeout:           dbTimeout,
+			BcryptCost:        bcryptCost,
+			isUniqueViolation: isPostgresUniqueViolation,
+		},
 	}
 }
 
+// postgresUniqueViolation is the SQLSTATE of a unique constraint violation
+const postgresUniqueViolation = "23505"
+
+func isPostgresUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == postgresUniqueViolation
+}
+
+// NewPostgresRepositoryWithOptions returns a repository on pool after tuning the pool with opts
+func NewPostgresRepositoryWithOptions(pool *sql.DB, opts PoolOptions) *PostgresRepository {
+	opts.Apply(pool)
+
+	repo := NewPostgresRepository(pool)
+	if opts.QueryTimeout > 0 {
+		repo.Timeout = opts.QueryTimeout
+	}
+	if opts.BcryptCost > 0 {
+		repo.BcryptCost = opts.BcryptCost
+	}
+
+	return repo
+}
+
+// observe reports the start of a query to the observer and returns the function
+// which reports its result
+func (u *sqlRepository) observe(ctx context.Context, operation string) (context.Context, func(err error)) {
+	if u.Observer == nil {
+		return ctx, func(error) {}
+	}
+
+	return u.Observer(ctx, operation)
+}
+
 // User is the structure which holds one user from the database.
 type User struct {
 	ID        int       `json:"id"`
@@ -38,88 +132,191 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Referrer  string    `json:"referrer,omitempty"`
+	Role      string    `json:"role"`
+	Timezone  string    `json:"timezone"`
+}
+
+// user roles, admins may operate on other users' points and the service itself
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// DefaultTimezone is the timezone of the users who didn't choose one
+const DefaultTimezone = "UTC"
+
+// Location returns the timezone of the user, the calendar days of the task limits are
+// counted in it. An unknown timezone falls back to UTC.
+func (u User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// timezone returns the timezone to store for the user, DefaultTimezone when it is empty
+func (u User) timezone() string {
+	if u.Timezone == "" {
+		return DefaultTimezone
+	}
+
+	return u.Timezone
 }
 
-// AddPoints adds  some points
-func (u *PostgresRepository) AddPoints(id, point int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// IsAdmin reports whether the user has the admin role
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// LogValue keeps the password hash out of the logs when a user is logged
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("id", u.ID),
+		slog.String("email", u.Email),
+		slog.Int("active", u.Active),
+		slog.Int("score", u.Score),
+		slog.String("role", u.Role),
+	)
+}
+
+// AddPoints adds  some points and records the change in the ledger, a negative point is
+// refused with ErrInsufficientPoints when the score would drop below zero
+func (u *sqlRepository) AddPoints(ctx context.Context, id, point int, change PointChange) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "AddPoints")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
 	stmt := `update users set
         score = score + $1,
         updated_at = $2
-		where id = $3
+		where id = $3 and score + $1 >= 0
+		returning score
 	`
 
-	_, err := db.ExecContext(ctx, stmt,
+	var balance int
+	err = tx.QueryRowContext(ctx, stmt,
 		point,
 		time.Now(),
 		id,
-	)
-
+	).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		if exists(ctx, tx, id) {
+			return ErrInsufficientPoints
+		}
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if err := recordTransaction(ctx, tx, id, point, balance, change); err != nil {
+		return err
+	}
 
+	return tx.Commit()
 }
 
-// GetAll returns a slice of all users, sorted by last name
-func (u *PostgresRepository) GetAll() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// rowQuerier is implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
 
-	query := `select id, email, first_name, last_name, active, score, created_at, updated_at, referrer
-	from users order by score desc`
+// exists reports whether the user is stored, it is used to tell why an update matched no row
+func exists(ctx context.Context, q rowQuerier, id int) bool {
+	var found int
+	err := q.QueryRowContext(ctx, "select 1 from users where id = $1", id).Scan(&found)
+	return err == nil
+}
 
-	rows, err := db.QueryContext(ctx, query)
+// affected returns the error of an update or delete, or ErrNotFound when it matched no row
+func affected(res sql.Result, err error) error {
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var users []*User
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
 
-	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Active,
-			&user.Score,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-			&user.Referrer,
-		)
+	return nil
+}
+
+// GetAll returns a slice of all users, sorted by last name
+func (u *sqlRepository) GetAll(ctx context.Context) (users []*User, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "GetAll")
+	defer func() { done(err) }()
+
+	query := `select id, email, first_name, last_name, active, score, created_at, updated_at, referrer, role, timezone
+	from users order by score desc`
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		users = nil
+		rows, err := db.QueryContext(ctx, query)
 		if err != nil {
-			log.Println("Error scanning", err)
-			return nil, err
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user User
+			err := rows.Scan(
+				&user.ID,
+				&user.Email,
+				&user.FirstName,
+				&user.LastName,
+				&user.Active,
+				&user.Score,
+				&user.CreatedAt,
+				&user.UpdatedAt,
+				&user.Referrer,
+				&user.Role,
+				&user.Timezone,
+			)
+			if err != nil {
+				return fmt.Errorf("scanning user: %w", err)
+			}
+
+			users = append(users, &user)
 		}
 
-		users = append(users, &user)
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return users, nil
 }
 
-// GetByEmail returns one user by email
-func (u *PostgresRepository) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// GetByEmail returns one user by email, it always reads the primary as the password
+// hash must be current when a user logs in
+func (u *sqlRepository) GetByEmail(ctx context.Context, email string) (_ *User, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "GetByEmail")
+	defer func() { done(err) }()
 
-	query := `select id, email, first_name, last_name, password, active, score, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, active, score, created_at, updated_at, role, timezone from users where email = $1`
 
 	var user User
-	row := db.QueryRowContext(ctx, query, email)
-
-	if db == nil {
-		return nil, errors.New("database connection is not initialized")
-	}
+	row := u.Conn.QueryRowContext(ctx, query, email)
 
-	err := row.Scan(
+	err = row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.FirstName,
@@ -129,8 +326,13 @@ func (u *PostgresRepository) GetByEmail(email string) (*User, error) {
 		&user.Score,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Role,
+		&user.Timezone,
 	)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -138,51 +340,107 @@ func (u *PostgresRepository) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// RedeemReferrer redeems the referrer with provided id and referrer, adds points to both users
-func (u *PostgresRepository) RedeemReferrer(id int, referrer string) error {
-	var referrerExists bool
-	err := db.QueryRow("SELECT 1 FROM users WHERE referrer = $1", referrer).Scan(&referrerExists)
+// RedeemReferrer redeems the referrer for the user with the id: the owners of the referrer get
+// ReferrerBonus and the user RefereeBonus. A user redeems one referrer only and never their own.
+func (u *sqlRepository) RedeemReferrer(ctx context.Context, id int, referrer string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "RedeemReferrer")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	if referrerExists {
-		_, err = db.Exec("UPDATE users SET score = score + 100 WHERE referrer = $1", referrer)
-		if err != nil {
-			return err
+	change := PointChange{Source: SourceReferral, Reason: "referrer redeemed", Reference: referrer, ActorID: id}
+
+	// the referred_by condition makes a second, even concurrent, redemption match no row
+	var balance int
+	err = tx.QueryRowContext(ctx, "UPDATE users SET score = score + $1, referred_by = $2 WHERE id = $3 AND referred_by IS NULL RETURNING score",
+		RefereeBonus, referrer, id).Scan(&balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		if exists(ctx, tx, id) {
+			return ErrAlreadyRedeemed
 		}
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordTransaction(ctx, tx, id, RefereeBonus, balance, change); err != nil {
+		return err
+	}
 
-		_, err = db.Exec("UPDATE users SET score = score + 25 WHERE id = $1", id)
-		if err != nil {
+	owners, err := updateScores(ctx, tx, "UPDATE users SET score = score + $1 WHERE referrer = $2 AND id <> $3 RETURNING id, score",
+		ReferrerBonus, referrer, id)
+	if err != nil {
+		return err
+	}
+	if len(owners) == 0 {
+		return ErrUnknownReferrer
+	}
+	// by id, so the ledger doesn't depend on the order of the map
+	for _, owner := range slices.Sorted(maps.Keys(owners)) {
+		if err := recordTransaction(ctx, tx, owner, ReferrerBonus, owners[owner], change); err != nil {
 			return err
 		}
+	}
+
+	return tx.Commit()
+}
+
+// updateScores runs an update returning id and score and collects the new scores by id,
+// the rows are read before the transactions get recorded on the same connection
+func updateScores(ctx context.Context, tx *sql.Tx, stmt string, args ...any) (map[int]int, error) {
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	scores := make(map[int]int)
+	for rows.Next() {
+		var id, score int
+		if err := rows.Scan(&id, &score); err != nil {
+			return nil, err
+		}
+		scores[id] = score
 	}
-	return nil
+
+	return scores, rows.Err()
 }
 
 // GetOne returns one user by id
-func (u *PostgresRepository) GetOne(id int) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *sqlRepository) GetOne(ctx context.Context, id int) (_ *User, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "GetOne")
+	defer func() { done(err) }()
 
-	query := `select id, email, first_name, last_name, active, score, created_at, updated_at, referrer from users where id = $1`
+	query := `select id, email, first_name, last_name, active, score, created_at, updated_at, referrer, role, timezone from users where id = $1`
 
 	var user User
-	row := db.QueryRowContext(ctx, query, id)
-
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Active,
-		&user.Score,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-		&user.Referrer,
-	)
+	err = u.read(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, id).Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.Score,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Referrer,
+			&user.Role,
+			&user.Timezone,
+		)
+	})
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -190,40 +448,108 @@ func (u *PostgresRepository) GetOne(id int) (*User, error) {
 	return &user, nil
 }
 
-// Update updates one user in the database, using the information stored in the receiver u
-func (u *PostgresRepository) Update(user User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Update updates one user in the database, using the information stored in the receiver u.
+// The timezone is changed by SetTimezone only.
+func (u *sqlRepository) Update(ctx context.Context, user User) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "Update")
+	defer func() { done(err) }()
 
 	stmt := `update users set
 		email = $1,
 		first_name = $2,
 		last_name = $3,
 		active = $4,
-		updated_at = $5
-		where id = $6
+		role = $5,
+		updated_at = $6
+		where id = $7
 	`
 
-	_, err := db.ExecContext(ctx, stmt,
+	err = affected(u.Conn.ExecContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
 		user.Active,
+		user.Role,
 		time.Now(),
 		user.ID,
-	)
+	))
+	if u.isUniqueViolation(err) {
+		return ErrDuplicateEmail
+	}
 
+	return err
+}
+
+// SetTimezone changes the timezone of the user. A new timezone starts a new day of the
+// task limits, so a change less than every after the last one is refused with a
+// *CooldownError wrapping ErrTimezoneChanged. Setting the current timezone again is a no-op.
+func (u *sqlRepository) SetTimezone(ctx context.Context, id int, timezone string, every time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "SetTimezone")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	var current string
+	var changedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `update users set score = score where id = $1 returning timezone, timezone_changed_at`, id).Scan(&current, &changedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if current == timezone {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if changedAt.Valid && now.Before(changedAt.Time.Add(every)) {
+		return &CooldownError{Err: ErrTimezoneChanged, AvailableAt: changedAt.Time.Add(every)}
+	}
+
+	stmt := `update users set timezone = $1, timezone_changed_at = $2, updated_at = $2 where id = $3`
+	if _, err := tx.ExecContext(ctx, stmt, timezone, now, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// UpdateScore provides whole new score to the user
-func (u *PostgresRepository) UpdateScore(user User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateScore provides whole new score to the user, the difference to the old score is
+// recorded in the ledger
+func (u *sqlRepository) UpdateScore(ctx context.Context, user User, change PointChange) (err error) {
+	if user.Score < 0 {
+		return ErrInsufficientPoints
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "UpdateScore")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the no-op update locks the row, so the old score can't change before the new one is set
+	var old int
+	err = tx.QueryRowContext(ctx, "update users set score = score where id = $1 returning score", user.ID).Scan(&old)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
 
 	stmt := `update users set
 		score = $1,
@@ -231,91 +557,122 @@ func (u *PostgresRepository) UpdateScore(user User) error {
 		where id = $3
 	`
 
-	_, err := db.ExecContext(ctx, stmt,
+	_, err = tx.ExecContext(ctx, stmt,
 		user.Score,
 		time.Now(),
 		user.ID,
 	)
-
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if err := recordTransaction(ctx, tx, user.ID, user.Score-old, user.Score, change); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // DeleteByID deletes one user from the database, by ID
-func (u *PostgresRepository) DeleteByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *sqlRepository) DeleteByID(ctx context.Context, id int) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "DeleteByID")
+	defer func() { done(err) }()
 
 	stmt := `delete from users where id = $1`
 
-	_, err := db.ExecContext(ctx, stmt, id)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return affected(u.Conn.ExecContext(ctx, stmt, id))
 }
 
 // Insert inserts a new user into the database, and returns the ID of the newly inserted row
-func (u *PostgresRepository) Insert(user User) (int, error) {
-	fmt.Println(user)
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *sqlRepository) Insert(ctx context.Context, user User) (_ int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "Insert")
+	defer func() { done(err) }()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), u.BcryptCost)
 	if err != nil {
 		return 0, err
 	}
 
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	user.Timezone = user.timezone()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, active, score, created_at, updated_at, referrer)
-		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, active, score, created_at, updated_at, referrer, role, timezone)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
 
-	err = db.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
-		hashedPassword,
+		string(hashedPassword),
 		user.Active,
 		user.Score,
 		time.Now(),
 		time.Now(),
 		user.Referrer,
+		user.Role,
+		user.Timezone,
 	).Scan(&newID)
 
+	if u.isUniqueViolation(err) {
+		return 0, ErrDuplicateEmail
+	}
 	if err != nil {
 		return 0, err
 	}
 
+	if user.Score != 0 {
+		initial := PointChange{Source: SourceInitial, Reason: "initial score"}
+		if err := recordTransaction(ctx, tx, newID, user.Score, user.Score, initial); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (u *PostgresRepository) ResetPassword(password string, user User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *sqlRepository) ResetPassword(ctx context.Context, password string, user User) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
 	defer cancel()
+	ctx, done := u.observe(ctx, "ResetPassword")
+	defer func() { done(err) }()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), u.BcryptCost)
 	if err != nil {
 		return err
 	}
 
 	stmt := `update users set password = $1 where id = $2`
-	_, err = db.ExecContext(ctx, stmt, hashedPassword, user.ID)
-	if err != nil {
-		return err
-	}
 
-	return nil
+	return affected(u.Conn.ExecContext(ctx, stmt, string(hashedPassword), user.ID))
 }
 
 // PasswordMatches uses Go's bcrypt package to compare a user supplied password
 // with the hash we have stored for a given user in the database. If the password
 // and hash match, we return true; otherwise, we return false.
-func (u *PostgresRepository) PasswordMatches(plainText string, user User) (bool, error) {
+func (u *sqlRepository) PasswordMatches(plainText string, user User) (bool, error) {
+	return passwordMatches(plainText, user)
+}
+
+// passwordMatches is shared by every repository, it compares plainText with the stored hash
+func passwordMatches(plainText string, user User) (bool, error) {
 	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(plainText))
 	if err != nil {
 		switch {
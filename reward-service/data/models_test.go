@@ -0,0 +1,47 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestPostgresRepository runs the contract against the database in TEST_POSTGRES_DSN,
+// the database is migrated and its users are deleted before every test
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	migrator, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	migrator.Close()
+
+	pool, err := sql.Open("pgx/v4", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	testRepositoryContract(t, func(t *testing.T) Repository {
+		if _, err := pool.ExecContext(context.Background(), "truncate users, point_transactions, idempotency_keys, task_completions, task_submissions, streaks, checkins, quests, quest_steps, quest_prerequisites, quest_progress, quest_completions restart identity"); err != nil {
+			t.Fatal(err)
+		}
+		// keep the tasks seeded by the migration
+		if _, err := pool.ExecContext(context.Background(), "delete from tasks where code not in ('telegram', 'x')"); err != nil {
+			t.Fatal(err)
+		}
+		repo := NewPostgresRepository(pool)
+		repo.BcryptCost = bcrypt.MinCost
+		return repo
+	})
+}
@@ -0,0 +1,563 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// SourceQuest is the ledger source of the bonuses of completed quests
+const SourceQuest = "quest"
+
+// events a quest step counts, they are read from the ledger entries
+const (
+	// QuestEventTask is a completion of the task named by the target of the step
+	QuestEventTask = "task"
+	// QuestEventReferral is a friend redeeming the referrer of the user
+	QuestEventReferral = "referral"
+	// QuestEventCheckin is a daily check-in
+	QuestEventCheckin = "checkin"
+	// QuestEventPoints counts the points earned from the source named by the target of the
+	// step, from every source when it is empty
+	QuestEventPoints = "points"
+)
+
+// QuestBook is the storage of the quests of the campaigns and of the progress of the users
+// on them, a quest is addressed by its code
+type QuestBook interface {
+	ListQuests(ctx context.Context) ([]Quest, error)
+	GetQuest(ctx context.Context, code string) (*Quest, error)
+	InsertQuest(ctx context.Context, quest Quest) (int, error)
+	DeleteQuest(ctx context.Context, code string) error
+	// UserQuests returns every quest with the progress of the user, sorted by code
+	UserQuests(ctx context.Context, userID int) ([]UserQuest, error)
+}
+
+// Quest is a list of steps which awards Bonus points once the user did every step. The
+// steps of an Ordered quest are done one after the other. A quest makes progress only
+// once the user completed every quest it Requires.
+type Quest struct {
+	ID          int         `json:"id"`
+	Code        string      `json:"code"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	Bonus       int         `json:"bonus"`
+	Ordered     bool        `json:"ordered"`
+	Requires    []string    `json:"requires"`
+	Steps       []QuestStep `json:"steps"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// QuestStep is done after Count events of its kind, see the QuestEvent constants
+type QuestStep struct {
+	Event  string `json:"event"`
+	Target string `json:"target,omitempty"`
+	Count  int    `json:"count"`
+}
+
+// UserQuest is a quest with the progress of a user on each of its steps
+type UserQuest struct {
+	Quest
+	Progress    []int      `json:"progress"`
+	Unlocked    bool       `json:"unlocked"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// counts returns how far the ledger entry of the user advances the step
+func (s QuestStep) counts(userID, delta int, change PointChange) int {
+	switch s.Event {
+	case QuestEventTask:
+		if change.Source == SourceTask && change.Reference == s.Target {
+			return 1
+		}
+	case QuestEventReferral:
+		// the user who redeemed the referrer is the actor of the entries of both users
+		if change.Source == SourceReferral && change.ActorID != userID {
+			return 1
+		}
+	case QuestEventCheckin:
+		if change.Source == SourceCheckin {
+			return 1
+		}
+	case QuestEventPoints:
+		if delta > 0 && (s.Target == "" || s.Target == change.Source) {
+			return delta
+		}
+	}
+
+	return 0
+}
+
+// advance returns the progress of the quest after the ledger entry of the user and whether
+// it changed. Only the first unfinished step of an ordered quest advances.
+func (q *Quest) advance(progress []int, userID, delta int, change PointChange) ([]int, bool) {
+	next := make([]int, len(q.Steps))
+	copy(next, progress)
+
+	changed := false
+	for i, step := range q.Steps {
+		if next[i] >= step.Count {
+			continue
+		}
+		if n := step.counts(userID, delta, change); n > 0 {
+			next[i] = min(next[i]+n, step.Count)
+			changed = true
+		}
+		if q.Ordered {
+			break
+		}
+	}
+
+	return next, changed
+}
+
+// done reports whether every step of the quest is done
+func (q *Quest) done(progress []int) bool {
+	for i, step := range q.Steps {
+		if i >= len(progress) || progress[i] < step.Count {
+			return false
+		}
+	}
+
+	return true
+}
+
+// questState is the progress of a user on the quests by code and when they completed them
+type questState struct {
+	progress  map[string][]int
+	completed map[string]time.Time
+}
+
+// unlocked reports whether the user completed every quest the quest requires
+func (s questState) unlocked(q *Quest) bool {
+	for _, code := range q.Requires {
+		if _, ok := s.completed[code]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// userQuest returns the quest with the progress of the user
+func (s questState) userQuest(q Quest) UserQuest {
+	uq := UserQuest{Quest: q, Progress: make([]int, len(q.Steps)), Unlocked: s.unlocked(&q)}
+	copy(uq.Progress, s.progress[q.Code])
+	if completedAt, ok := s.completed[q.Code]; ok {
+		uq.CompletedAt = &completedAt
+	}
+
+	return uq
+}
+
+// questEvent reports whether the ledger entry can advance quests. The initial score, the
+// bonuses of the quests themselves and the arbitrary points of custom tasks don't.
+func questEvent(change PointChange) bool {
+	if change.Source == SourceTask && change.Reference == ReferenceCustomTask {
+		return false
+	}
+
+	return change.Source != SourceInitial && change.Source != SourceQuest
+}
+
+// rowsQuerier is implemented by both *sql.DB and *sql.Tx
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadQuests reads the quest with the code with its steps and prerequisites, every quest
+// sorted by code when the code is empty
+func loadQuests(ctx context.Context, q rowsQuerier, code string) ([]Quest, error) {
+	quests := []Quest{}
+	byID := make(map[int]int)
+
+	rows, err := q.QueryContext(ctx, `select id, code, title, description, bonus, ordered, created_at
+		from quests where ($1 = '' or code = $1) order by code`, code)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		quest := Quest{Requires: []string{}, Steps: []QuestStep{}}
+		err := rows.Scan(&quest.ID, &quest.Code, &quest.Title, &quest.Description, &quest.Bonus, &quest.Ordered, &quest.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning quest: %w", err)
+		}
+		byID[quest.ID] = len(quests)
+		quests = append(quests, quest)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(quests) == 0 {
+		return quests, nil
+	}
+
+	rows, err = q.QueryContext(ctx, `select s.quest_id, s.event, s.target, s.target_count
+		from quest_steps s join quests q on q.id = s.quest_id
+		where ($1 = '' or q.code = $1) order by s.quest_id, s.step`, code)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var questID int
+		var step QuestStep
+		if err := rows.Scan(&questID, &step.Event, &step.Target, &step.Count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning quest step: %w", err)
+		}
+		if i, ok := byID[questID]; ok {
+			quests[i].Steps = append(quests[i].Steps, step)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.QueryContext(ctx, `select p.quest_id, r.code
+		from quest_prerequisites p join quests q on q.id = p.quest_id join quests r on r.id = p.required_id
+		where ($1 = '' or q.code = $1) order by r.code`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var questID int
+		var required string
+		if err := rows.Scan(&questID, &required); err != nil {
+			return nil, fmt.Errorf("scanning quest prerequisite: %w", err)
+		}
+		if i, ok := byID[questID]; ok {
+			quests[i].Requires = append(quests[i].Requires, required)
+		}
+	}
+
+	return quests, rows.Err()
+}
+
+// readQuestState reads the progress of the user on the quests
+func readQuestState(ctx context.Context, q rowsQuerier, userID int) (questState, error) {
+	state := questState{progress: make(map[string][]int), completed: make(map[string]time.Time)}
+
+	rows, err := q.QueryContext(ctx, `select q.code, p.step, p.progress
+		from quest_progress p join quests q on q.id = p.quest_id where p.user_id = $1`, userID)
+	if err != nil {
+		return state, err
+	}
+	for rows.Next() {
+		var code string
+		var step, progress int
+		if err := rows.Scan(&code, &step, &progress); err != nil {
+			rows.Close()
+			return state, fmt.Errorf("scanning quest progress: %w", err)
+		}
+		steps := state.progress[code]
+		for len(steps) <= step {
+			steps = append(steps, 0)
+		}
+		steps[step] = progress
+		state.progress[code] = steps
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	rows, err = q.QueryContext(ctx, `select q.code, c.completed_at
+		from quest_completions c join quests q on q.id = c.quest_id where c.user_id = $1`, userID)
+	if err != nil {
+		return state, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var completedAt time.Time
+		if err := rows.Scan(&code, &completedAt); err != nil {
+			return state, fmt.Errorf("scanning quest completion: %w", err)
+		}
+		state.completed[code] = completedAt
+	}
+
+	return state, rows.Err()
+}
+
+// sourceEvent returns the quest event of the ledger entries of the source, points aside
+func sourceEvent(source string) string {
+	switch source {
+	case SourceTask:
+		return QuestEventTask
+	case SourceReferral:
+		return QuestEventReferral
+	case SourceCheckin:
+		return QuestEventCheckin
+	}
+
+	return ""
+}
+
+// loadActiveQuests reads the unlocked quests the user didn't complete yet which have a step
+// the ledger entry can advance, with their steps and the progress of the user, sorted by code
+func loadActiveQuests(ctx context.Context, q rowsQuerier, userID, delta int, change PointChange) ([]UserQuest, error) {
+	rows, err := q.QueryContext(ctx, `select q.id, q.code, q.bonus, q.ordered, s.event, s.target, s.target_count, coalesce(p.progress, 0)
+		from quests q join quest_steps s on s.quest_id = q.id
+		left join quest_progress p on p.user_id = $1 and p.quest_id = q.id and p.step = s.step
+		where q.id in (
+			select m.quest_id from quest_steps m
+			where (m.event = $2 and (m.event <> 'task' or m.target = $3))
+				or (m.event = 'points' and $4 > 0 and (m.target = '' or m.target = $5))
+		)
+		and not exists (select 1 from quest_completions c where c.user_id = $1 and c.quest_id = q.id)
+		and not exists (
+			select 1 from quest_prerequisites r
+			where r.quest_id = q.id and not exists (
+				select 1 from quest_completions c where c.user_id = $1 and c.quest_id = r.required_id
+			)
+		)
+		order by q.code, s.step`, userID, sourceEvent(change.Source), change.Reference, delta, change.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quests := []UserQuest{}
+	for rows.Next() {
+		var quest Quest
+		var step QuestStep
+		var progress int
+		err := rows.Scan(&quest.ID, &quest.Code, &quest.Bonus, &quest.Ordered, &step.Event, &step.Target, &step.Count, &progress)
+		if err != nil {
+			return nil, fmt.Errorf("scanning quest step: %w", err)
+		}
+		if len(quests) == 0 || quests[len(quests)-1].ID != quest.ID {
+			quests = append(quests, UserQuest{Quest: quest, Unlocked: true})
+		}
+		last := &quests[len(quests)-1]
+		last.Steps = append(last.Steps, step)
+		last.Progress = append(last.Progress, progress)
+	}
+
+	return quests, rows.Err()
+}
+
+// advanceQuests applies the ledger entry of the user to their unlocked quests in the
+// transaction recording it, and awards the bonus of every quest it completes. The quests
+// it unlocks start counting with the next entry.
+func advanceQuests(ctx context.Context, tx *sql.Tx, userID, delta int, change PointChange) error {
+	if !questEvent(change) {
+		return nil
+	}
+	quests, err := loadActiveQuests(ctx, tx, userID, delta, change)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, quest := range quests {
+		progress, changed := quest.advance(quest.Progress, userID, delta, change)
+		if !changed {
+			continue
+		}
+
+		for step, count := range progress {
+			if count == quest.Progress[step] {
+				continue
+			}
+			stmt := `insert into quest_progress (user_id, quest_id, step, progress) values ($1, $2, $3, $4)
+				on conflict (user_id, quest_id, step) do update set progress = excluded.progress`
+			if _, err := tx.ExecContext(ctx, stmt, userID, quest.ID, step, count); err != nil {
+				return fmt.Errorf("recording quest progress: %w", err)
+			}
+		}
+		if !quest.done(progress) {
+			continue
+		}
+
+		stmt := `insert into quest_completions (user_id, quest_id, bonus, completed_at) values ($1, $2, $3, $4)`
+		if _, err := tx.ExecContext(ctx, stmt, userID, quest.ID, quest.Bonus, now); err != nil {
+			return fmt.Errorf("recording quest completion: %w", err)
+		}
+		var score int
+		err = tx.QueryRowContext(ctx, `update users set score = score + $1, updated_at = $2 where id = $3 returning score`,
+			quest.Bonus, time.Now(), userID).Scan(&score)
+		if err != nil {
+			return err
+		}
+		bonus := PointChange{Source: SourceQuest, Reason: "quest completed", Reference: quest.Code}
+		if err := recordTransaction(ctx, tx, userID, quest.Bonus, score, bonus); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListQuests returns every quest sorted by code
+func (u *sqlRepository) ListQuests(ctx context.Context) (quests []Quest, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "ListQuests")
+	defer func() { done(err) }()
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		quests, err = loadQuests(ctx, db, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return quests, nil
+}
+
+// GetQuest returns the quest with the code
+func (u *sqlRepository) GetQuest(ctx context.Context, code string) (quest *Quest, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "GetQuest")
+	defer func() { done(err) }()
+
+	var quests []Quest
+	err = u.read(ctx, func(db *sql.DB) error {
+		quests, err = loadQuests(ctx, db, code)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code == "" || len(quests) == 0 {
+		return nil, ErrQuestNotFound
+	}
+
+	return &quests[0], nil
+}
+
+// InsertQuest adds the quest with its steps and prerequisites and returns its id, every
+// quest it requires must exist
+func (u *sqlRepository) InsertQuest(ctx context.Context, quest Quest) (id int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "InsertQuest")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into quests (code, title, description, bonus, ordered, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+	err = tx.QueryRowContext(ctx, stmt, quest.Code, quest.Title, quest.Description, quest.Bonus, quest.Ordered, time.Now().UTC()).Scan(&id)
+	if u.isUniqueViolation(err) {
+		return 0, ErrDuplicateQuest
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for i, step := range quest.Steps {
+		stmt := `insert into quest_steps (quest_id, step, event, target, target_count) values ($1, $2, $3, $4, $5)`
+		if _, err := tx.ExecContext(ctx, stmt, id, i, step.Event, step.Target, step.Count); err != nil {
+			return 0, err
+		}
+	}
+	for i, code := range quest.Requires {
+		if slices.Contains(quest.Requires[:i], code) {
+			continue
+		}
+		var required int
+		err := tx.QueryRowContext(ctx, `select id from quests where code = $1 and id <> $2`, code, id).Scan(&required)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownPrerequisite, code)
+		}
+		if err != nil {
+			return 0, err
+		}
+		stmt := `insert into quest_prerequisites (quest_id, required_id) values ($1, $2)`
+		if _, err := tx.ExecContext(ctx, stmt, id, required); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// DeleteQuest removes the quest with the progress of the users on it, as long as no user
+// completed it and no other quest requires it
+func (u *sqlRepository) DeleteQuest(ctx context.Context, code string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "DeleteQuest")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var used bool
+	query := `select id, exists (select 1 from quest_completions where quest_id = quests.id)
+		or exists (select 1 from quest_prerequisites where required_id = quests.id)
+		from quests where code = $1`
+	err = tx.QueryRowContext(ctx, query, code).Scan(&id, &used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrQuestNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrQuestInUse
+	}
+
+	if _, err := tx.ExecContext(ctx, `delete from quests where id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UserQuests returns every quest with the progress of the user, sorted by code
+func (u *sqlRepository) UserQuests(ctx context.Context, userID int) (userQuests []UserQuest, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "UserQuests")
+	defer func() { done(err) }()
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		if !exists(ctx, db, userID) {
+			return ErrNotFound
+		}
+		quests, err := loadQuests(ctx, db, "")
+		if err != nil {
+			return err
+		}
+		state, err := readQuestState(ctx, db, userID)
+		if err != nil {
+			return err
+		}
+
+		userQuests = make([]UserQuest, 0, len(quests))
+		for _, quest := range quests {
+			userQuests = append(userQuests, state.userQuest(quest))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return userQuests, nil
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuestAdvance(t *testing.T) {
+	const userID = 1
+	quest := Quest{Steps: []QuestStep{
+		{Event: QuestEventTask, Target: "telegram", Count: 1},
+		{Event: QuestEventReferral, Count: 2},
+		{Event: QuestEventCheckin, Count: 3},
+		{Event: QuestEventPoints, Target: SourceAdmin, Count: 50},
+	}}
+
+	tests := []struct {
+		name     string
+		delta    int
+		change   PointChange
+		progress string
+	}{
+		{"another task", 75, PointChange{Source: SourceTask, Reference: "x"}, "[0 0 0 0]"},
+		{"the task", 50, PointChange{Source: SourceTask, Reference: "telegram"}, "[1 0 0 0]"},
+		{"the task again is capped", 50, PointChange{Source: SourceTask, Reference: "telegram"}, "[1 0 0 0]"},
+		{"redeeming a referrer", 5, PointChange{Source: SourceReferral, ActorID: userID}, "[1 0 0 0]"},
+		{"a friend redeeming", 10, PointChange{Source: SourceReferral, ActorID: 2}, "[1 1 0 0]"},
+		{"a check-in", 10, PointChange{Source: SourceCheckin}, "[1 1 1 0]"},
+		{"points taken away", -30, PointChange{Source: SourceAdmin}, "[1 1 1 0]"},
+		{"points added", 30, PointChange{Source: SourceAdmin}, "[1 1 1 30]"},
+		{"points are capped", 30, PointChange{Source: SourceAdmin}, "[1 1 1 50]"},
+	}
+
+	progress := []int{}
+	for _, tt := range tests {
+		progress, _ = quest.advance(progress, userID, tt.delta, tt.change)
+		if got := fmt.Sprint(progress); got != tt.progress {
+			t.Errorf("%s: progress = %s, want %s", tt.name, got, tt.progress)
+		}
+	}
+	if quest.done(progress) {
+		t.Errorf("quest done with progress %v", progress)
+	}
+
+	quest.Ordered = true
+	progress, changed := quest.advance([]int{1, 0, 2, 0}, userID, 10, PointChange{Source: SourceCheckin})
+	if changed || fmt.Sprint(progress) != "[1 0 2 0]" {
+		t.Errorf("ordered quest advanced a later step: %v", progress)
+	}
+	progress, changed = quest.advance(progress, userID, 10, PointChange{Source: SourceReferral, ActorID: 2})
+	if !changed || fmt.Sprint(progress) != "[1 1 2 0]" {
+		t.Errorf("ordered quest progress = %v", progress)
+	}
+
+	if questEvent(PointChange{Source: SourceQuest}) || questEvent(PointChange{Source: SourceInitial}) {
+		t.Error("quest bonuses and initial scores advance quests")
+	}
+	if questEvent(PointChange{Source: SourceTask, Reference: ReferenceCustomTask}) {
+		t.Error("the points of custom tasks advance quests")
+	}
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+)
+
+// ReplicaRouter is implemented by the repositories which can send their read-only
+// queries to a read replica
+type ReplicaRouter interface {
+	// SetReplica routes the read-only queries to pool from now on
+	SetReplica(pool *sql.DB)
+	// CheckReplica pings the replica and marks it healthy or unhealthy, reads go to
+	// the primary while the replica is unhealthy
+	CheckReplica(ctx context.Context) error
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns a context whose reads go to the primary even when a replica is
+// configured, so a caller reads its own writes despite the replication lag
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// usesPrimary reports whether WithPrimary was applied to ctx
+func usesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// replica is the read replica of a repository with its last known health
+type replica struct {
+	pool    *sql.DB
+	healthy atomic.Bool
+}
+
+// SetReplica routes the read-only queries to pool, the replica counts as healthy
+// until a check or a query on it fails
+func (u *sqlRepository) SetReplica(pool *sql.DB) {
+	r := &replica{pool: pool}
+	r.healthy.Store(true)
+	u.replica = r
+}
+
+// CheckReplica pings the replica and remembers the result
+func (u *sqlRepository) CheckReplica(ctx context.Context) error {
+	if u.replica == nil {
+		return errors.New("no replica configured")
+	}
+
+	err := u.replica.pool.PingContext(ctx)
+	u.replica.healthy.Store(err == nil)
+
+	return err
+}
+
+// read runs the read-only query fn on the replica when one is configured, healthy and
+// ctx doesn't ask for the primary. When the replica fails for another reason than
+// a missing row it is marked unhealthy and fn runs again on the primary.
+func (u *sqlRepository) read(ctx context.Context, fn func(db *sql.DB) error) error {
+	r := u.replica
+	if r == nil || !r.healthy.Load() || usesPrimary(ctx) {
+		return fn(u.Conn)
+	}
+
+	err := fn(r.pool)
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+
+	r.healthy.Store(false)
+	return fn(u.Conn)
+}
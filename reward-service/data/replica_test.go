@@ -0,0 +1,38 @@
+package data
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+	primary := newSQLiteRepository(t)
+	replica := newSQLiteRepository(t)
+
+	// the replica lags behind: it doesn't have the user yet
+	id := mustInsert(t, primary, User{Email: "lag@example.com"})
+	primary.SetReplica(replica.Conn)
+
+	if _, err := primary.GetOne(ctx, id); err == nil {
+		t.Error("GetOne read the primary although the replica is healthy")
+	}
+	if user, err := primary.GetOne(WithPrimary(ctx), id); err != nil || user.ID != id {
+		t.Errorf("GetOne with WithPrimary returned %v, %v", user, err)
+	}
+	if users, err := primary.GetAll(ctx); err != nil || len(users) != 0 {
+		t.Errorf("GetAll returned %d users from the replica, %v", len(users), err)
+	}
+	if _, err := primary.GetByEmail(ctx, "lag@example.com"); err != nil {
+		t.Errorf("GetByEmail didn't read the primary: %v", err)
+	}
+
+	// a failing replica is taken out of the rotation and the read is retried on the primary
+	replica.Conn.Close()
+	if user, err := primary.GetOne(ctx, id); err != nil || user.ID != id {
+		t.Errorf("GetOne after the replica failed returned %v, %v", user, err)
+	}
+	if err := primary.CheckReplica(ctx); err == nil {
+		t.Error("CheckReplica of a closed replica succeeded")
+	}
+}
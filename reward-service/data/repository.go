@@ -1,14 +1,49 @@
 package data
 
+import (
+	"context"
+	"time"
+)
+
+// QueryObserver is called when a repository query starts, the returned function is
+// called with the result once the query finished. It lets the caller attach metrics
+// without the data package knowing about them.
+type QueryObserver func(ctx context.Context, operation string) (context.Context, func(err error))
+
+// ChainObservers combines several observers into one, they are started in the given
+// order and finished in the reverse order
+func ChainObservers(observers ...QueryObserver) QueryObserver {
+	return func(ctx context.Context, operation string) (context.Context, func(err error)) {
+		dones := make([]func(error), len(observers))
+		for i, observe := range observers {
+			ctx, dones[i] = observe(ctx, operation)
+		}
+
+		return ctx, func(err error) {
+			for i := len(dones) - 1; i >= 0; i-- {
+				dones[i](err)
+			}
+		}
+	}
+}
+
+// Repository is the storage of users and their points. Every method which talks to
+// the database takes the caller's context, so cancelling a request cancels its queries.
 type Repository interface {
-	GetAll() ([]*User, error)
-	GetByEmail(email string) (*User, error)
-	GetOne(id int) (*User, error)
-	Update(user User) error
-	DeleteByID(id int) error
-	Insert(user User) (int, error)
-	ResetPassword(password string, user User) error
+	GetAll(ctx context.Context) ([]*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetOne(ctx context.Context, id int) (*User, error)
+	Update(ctx context.Context, user User) error
+	SetTimezone(ctx context.Context, id int, timezone string, every time.Duration) error
+	DeleteByID(ctx context.Context, id int) error
+	Insert(ctx context.Context, user User) (int, error)
+	ResetPassword(ctx context.Context, password string, user User) error
 	PasswordMatches(plainText string, user User) (bool, error)
-	AddPoints(id, point int) error
-	RedeemReferrer(id int, referrer string) error
+	AddPoints(ctx context.Context, id, point int, change PointChange) error
+	RedeemReferrer(ctx context.Context, id int, referrer string) error
+	PointHistory(ctx context.Context, userID int, page HistoryPage) ([]Transaction, error)
+	TaskCatalog
+	SubmissionQueue
+	CheckinLog
+	QuestBook
 }
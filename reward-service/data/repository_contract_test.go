@@ -0,0 +1,1216 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRepositoryContract runs the behaviour every Repository implementation must share,
+// newRepo must return an empty repository for each call
+func testRepositoryContract(t *testing.T, newRepo func(t *testing.T) Repository) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo Repository)
+	}{
+		{"InsertAndGet", testInsertAndGet},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"PasswordMatches", testPasswordMatches},
+		{"GetAllSortedByScore", testGetAllSortedByScore},
+		{"AddPoints", testAddPoints},
+		{"ConcurrentAddPoints", testConcurrentAddPoints},
+		{"Update", testUpdate},
+		{"ResetPassword", testResetPassword},
+		{"DeleteByID", testDeleteByID},
+		{"RedeemReferrer", testRedeemReferrer},
+		{"RedeemUnknownReferrer", testRedeemUnknownReferrer},
+		{"RedeemTwice", testRedeemTwice},
+		{"InsufficientPoints", testInsufficientPoints},
+		{"MissingUser", testMissingUser},
+		{"Ledger", testLedger},
+		{"LedgerPagination", testLedgerPagination},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"TaskCatalog", testTaskCatalog},
+		{"CompleteTask", testCompleteTask},
+		{"ConcurrentCompleteTask", testConcurrentCompleteTask},
+		{"PendingTask", testPendingTask},
+		{"TaskLimits", testTaskLimits},
+		{"SetTimezone", testSetTimezone},
+		{"Submissions", testSubmissions},
+		{"CheckIn", testCheckIn},
+		{"Quests", testQuests},
+		{"CancelledContext", testCancelledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+// taskChange is the ledger entry of the points added by the tests
+var taskChange = PointChange{Source: SourceTask, Reason: "test"}
+
+func mustInsert(t *testing.T, repo Repository, user User) int {
+	t.Helper()
+
+	if user.Password == "" {
+		user.Password = "verysecret"
+	}
+	id, err := repo.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Insert(%s): %v", user.Email, err)
+	}
+
+	return id
+}
+
+func mustGetOne(t *testing.T, repo Repository, id int) *User {
+	t.Helper()
+
+	user, err := repo.GetOne(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetOne(%d): %v", id, err)
+	}
+
+	return user
+}
+
+func testInsertAndGet(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "ann@example.com", FirstName: "Ann", LastName: "Lee", Active: 1, Score: 10, Referrer: "ANN"})
+
+	user := mustGetOne(t, repo, id)
+	if user.Email != "ann@example.com" || user.FirstName != "Ann" || user.LastName != "Lee" {
+		t.Errorf("GetOne returned %+v", user)
+	}
+	if user.Score != 10 || user.Active != 1 || user.Referrer != "ANN" || user.Role != RoleUser {
+		t.Errorf("GetOne returned %+v", user)
+	}
+	if user.CreatedAt.IsZero() {
+		t.Error("CreatedAt is not set")
+	}
+
+	byEmail, err := repo.GetByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+	if byEmail.ID != id {
+		t.Errorf("GetByEmail returned id %d, want %d", byEmail.ID, id)
+	}
+	if byEmail.Password == "" || byEmail.Password == "verysecret" {
+		t.Errorf("password is not hashed: %q", byEmail.Password)
+	}
+
+	if _, err := repo.GetOne(ctx, id+1000); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetOne of a missing user returned %v", err)
+	}
+	if _, err := repo.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByEmail of a missing user returned %v", err)
+	}
+}
+
+func testDuplicateEmail(t *testing.T, repo Repository) {
+	mustInsert(t, repo, User{Email: "dup@example.com", Active: 1})
+
+	if _, err := repo.Insert(context.Background(), User{Email: "dup@example.com", Password: "x"}); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("inserting a duplicate email returned %v", err)
+	}
+
+	other := mustGetOne(t, repo, mustInsert(t, repo, User{Email: "other@example.com"}))
+	other.Email = "dup@example.com"
+	if err := repo.Update(context.Background(), *other); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("updating to a duplicate email returned %v", err)
+	}
+}
+
+func testPasswordMatches(t *testing.T, repo Repository) {
+	mustInsert(t, repo, User{Email: "pw@example.com", Password: "right-password", Active: 1})
+
+	user, err := repo.GetByEmail(context.Background(), "pw@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := repo.PasswordMatches("right-password", *user); err != nil || !ok {
+		t.Errorf("PasswordMatches(right) = %v, %v", ok, err)
+	}
+	if ok, err := repo.PasswordMatches("wrong-password", *user); err != nil || ok {
+		t.Errorf("PasswordMatches(wrong) = %v, %v", ok, err)
+	}
+}
+
+func testGetAllSortedByScore(t *testing.T, repo Repository) {
+	mustInsert(t, repo, User{Email: "low@example.com", Score: 5})
+	mustInsert(t, repo, User{Email: "high@example.com", Score: 50})
+	mustInsert(t, repo, User{Email: "mid@example.com", Score: 20})
+
+	users, err := repo.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("GetAll returned %d users", len(users))
+	}
+
+	want := []string{"high@example.com", "mid@example.com", "low@example.com"}
+	for i, user := range users {
+		if user.Email != want[i] {
+			t.Errorf("position %d: got %s, want %s", i, user.Email, want[i])
+		}
+		if user.Password != "" {
+			t.Errorf("GetAll leaked the password hash of %s", user.Email)
+		}
+	}
+}
+
+func testAddPoints(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "points@example.com", Score: 10})
+
+	if err := repo.AddPoints(ctx, id, 50, taskChange); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddPoints(ctx, id, -15, taskChange); err != nil {
+		t.Fatal(err)
+	}
+
+	if score := mustGetOne(t, repo, id).Score; score != 45 {
+		t.Errorf("score = %d, want 45", score)
+	}
+}
+
+func testConcurrentAddPoints(t *testing.T, repo Repository) {
+	id := mustInsert(t, repo, User{Email: "race@example.com"})
+
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := repo.AddPoints(context.Background(), id, 1, taskChange); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if score := mustGetOne(t, repo, id).Score; score != workers {
+		t.Errorf("score = %d, want %d", score, workers)
+	}
+}
+
+func testUpdate(t *testing.T, repo Repository) {
+	id := mustInsert(t, repo, User{Email: "old@example.com", FirstName: "Old", Active: 1, Score: 7})
+
+	user := mustGetOne(t, repo, id)
+	user.Email = "new@example.com"
+	user.FirstName = "New"
+	user.Active = 0
+	user.Role = RoleAdmin
+	user.Score = 1000
+	if err := repo.Update(context.Background(), *user); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mustGetOne(t, repo, id)
+	if got.Email != "new@example.com" || got.FirstName != "New" || got.Active != 0 || !got.IsAdmin() {
+		t.Errorf("Update stored %+v", got)
+	}
+	if got.Score != 7 {
+		t.Errorf("Update changed the score to %d", got.Score)
+	}
+}
+
+func testResetPassword(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "reset@example.com", Password: "before"})
+
+	if err := repo.ResetPassword(ctx, "after", User{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.GetByEmail(ctx, "reset@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := repo.PasswordMatches("after", *user); !ok {
+		t.Error("the new password doesn't match")
+	}
+	if ok, _ := repo.PasswordMatches("before", *user); ok {
+		t.Error("the old password still matches")
+	}
+}
+
+func testDeleteByID(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "gone@example.com", Score: 10})
+
+	if err := repo.DeleteByID(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetOne(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetOne after delete returned %v", err)
+	}
+
+	// the ledger is append-only, it outlives the user
+	history, err := repo.PointHistory(ctx, id, HistoryPage{})
+	if err != nil || len(history) != 1 || history[0].Delta != 10 {
+		t.Errorf("PointHistory of a deleted user = %+v, %v", history, err)
+	}
+}
+
+func testRedeemReferrer(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	var owners []int
+	for i := range 4 {
+		owners = append(owners, mustInsert(t, repo, User{Email: fmt.Sprintf("owner%d@example.com", i), Referrer: "FRIEND"}))
+	}
+	referee := mustInsert(t, repo, User{Email: "referee@example.com"})
+
+	if err := repo.RedeemReferrer(ctx, referee, "FRIEND"); err != nil {
+		t.Fatal(err)
+	}
+
+	var last int64
+	for _, owner := range owners {
+		if score := mustGetOne(t, repo, owner).Score; score != ReferrerBonus {
+			t.Errorf("owner score = %d, want %d", score, ReferrerBonus)
+		}
+		// the owners' entries are recorded by their id
+		history, err := repo.PointHistory(ctx, owner, HistoryPage{})
+		if err != nil || len(history) != 1 || history[0].ID < last {
+			t.Errorf("ledger of owner %d = %+v, %v, want one entry after %d", owner, history, err, last)
+		} else {
+			last = history[0].ID
+		}
+	}
+	if score := mustGetOne(t, repo, referee).Score; score != RefereeBonus {
+		t.Errorf("referee score = %d, want %d", score, RefereeBonus)
+	}
+}
+
+func testRedeemUnknownReferrer(t *testing.T, repo Repository) {
+	referee := mustInsert(t, repo, User{Email: "lonely@example.com"})
+
+	if err := repo.RedeemReferrer(context.Background(), referee, "NOBODY"); !errors.Is(err, ErrUnknownReferrer) {
+		t.Errorf("redeeming an unknown referrer returned %v", err)
+	}
+	if score := mustGetOne(t, repo, referee).Score; score != 0 {
+		t.Errorf("score = %d after a failed redemption", score)
+	}
+
+	self := mustInsert(t, repo, User{Email: "self@example.com", Referrer: "SELF"})
+	if err := repo.RedeemReferrer(context.Background(), self, "SELF"); !errors.Is(err, ErrUnknownReferrer) {
+		t.Errorf("redeeming the own referrer returned %v", err)
+	}
+	if score := mustGetOne(t, repo, self).Score; score != 0 {
+		t.Errorf("score = %d after redeeming the own referrer", score)
+	}
+}
+
+func testRedeemTwice(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	owner := mustInsert(t, repo, User{Email: "owner@example.com", Referrer: "ONCE"})
+	referee := mustInsert(t, repo, User{Email: "referee@example.com"})
+
+	if err := repo.RedeemReferrer(ctx, referee, "ONCE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RedeemReferrer(ctx, referee, "ONCE"); !errors.Is(err, ErrAlreadyRedeemed) {
+		t.Errorf("second redemption returned %v", err)
+	}
+	if score := mustGetOne(t, repo, owner).Score; score != ReferrerBonus {
+		t.Errorf("owner score = %d after a second redemption", score)
+	}
+	if err := repo.RedeemReferrer(ctx, referee+1000, "ONCE"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("redemption by a missing user returned %v", err)
+	}
+}
+
+func testInsufficientPoints(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "poor@example.com", Score: 10})
+
+	if err := repo.AddPoints(ctx, id, -11, taskChange); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("AddPoints below zero returned %v", err)
+	}
+	if err := repo.AddPoints(ctx, id, -10, taskChange); err != nil {
+		t.Errorf("AddPoints down to zero returned %v", err)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 0 {
+		t.Errorf("score = %d, want 0", score)
+	}
+}
+
+func testMissingUser(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	const missing = 4242
+
+	if err := repo.AddPoints(ctx, missing, 1, taskChange); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddPoints returned %v", err)
+	}
+	if err := repo.Update(ctx, User{ID: missing, Email: "missing@example.com"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update returned %v", err)
+	}
+	if err := repo.ResetPassword(ctx, "x", User{ID: missing}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResetPassword returned %v", err)
+	}
+	if err := repo.DeleteByID(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteByID returned %v", err)
+	}
+}
+
+func testCancelledContext(t *testing.T, repo Repository) {
+	id := mustInsert(t, repo, User{Email: "cancel@example.com"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.GetOne(ctx, id); err == nil {
+		t.Error("GetOne with a cancelled context succeeded")
+	}
+	if err := repo.AddPoints(ctx, id, 10, taskChange); err == nil {
+		t.Error("AddPoints with a cancelled context succeeded")
+	}
+}
+
+func testLedger(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	owner := mustInsert(t, repo, User{Email: "owner@example.com", Referrer: "LEDGER"})
+	id := mustInsert(t, repo, User{Email: "ledger@example.com", Score: 10})
+
+	if err := repo.AddPoints(ctx, id, 50, PointChange{Source: SourceTask, Reference: "telegram", ActorID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddPoints(ctx, id, -100, taskChange); !errors.Is(err, ErrInsufficientPoints) {
+		t.Fatalf("AddPoints below zero returned %v", err)
+	}
+	if err := repo.AddPoints(ctx, id, -15, PointChange{Source: SourceAdmin, Reason: "cheating"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RedeemReferrer(ctx, id, "LEDGER"); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := repo.PointHistory(ctx, id, HistoryPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		delta, balance int
+		source         string
+	}{
+		{RefereeBonus, 45 + RefereeBonus, SourceReferral},
+		{-15, 45, SourceAdmin},
+		{50, 60, SourceTask},
+		{10, 10, SourceInitial},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history has %d entries: %+v", len(history), history)
+	}
+	for i, w := range want {
+		got := history[i]
+		if got.Delta != w.delta || got.Balance != w.balance || got.Source != w.source || got.UserID != id {
+			t.Errorf("entry %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if history[1].Reason != "cheating" || history[2].Reference != "telegram" || history[2].ActorID != id {
+		t.Errorf("metadata not recorded: %+v", history)
+	}
+	if history[0].Reference != "LEDGER" || history[0].ActorID != id {
+		t.Errorf("referral entry = %+v", history[0])
+	}
+
+	ownerHistory, err := repo.PointHistory(ctx, owner, HistoryPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ownerHistory) != 1 || ownerHistory[0].Delta != ReferrerBonus || ownerHistory[0].Balance != ReferrerBonus {
+		t.Errorf("owner history = %+v", ownerHistory)
+	}
+}
+
+func testLedgerPagination(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "pages@example.com"})
+	for i := 1; i <= 5; i++ {
+		if err := repo.AddPoints(ctx, id, i, taskChange); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deltas []int
+	page := HistoryPage{Limit: 2}
+	for {
+		history, err := repo.PointHistory(ctx, id, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) == 0 {
+			break
+		}
+		if len(history) > 2 {
+			t.Fatalf("page has %d entries", len(history))
+		}
+		for _, entry := range history {
+			deltas = append(deltas, entry.Delta)
+		}
+		page.Before = history[len(history)-1].ID
+	}
+
+	if fmt.Sprint(deltas) != "[5 4 3 2 1]" {
+		t.Errorf("paged deltas = %v", deltas)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, repo Repository) {
+	store, ok := repo.(IdempotencyStore)
+	if !ok {
+		t.Skip("the repository doesn't store idempotency keys")
+	}
+
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "retry@example.com"})
+	since := time.Now().Add(-time.Hour)
+
+	record, err := store.ReserveIdempotencyKey(ctx, id, "k1", "POST /a", since, time.Hour)
+	if err != nil || record != nil {
+		t.Fatalf("first reservation returned %+v, %v", record, err)
+	}
+
+	// a concurrent retry sees the key in flight
+	record, err = store.ReserveIdempotencyKey(ctx, id, "k1", "POST /a", since, time.Hour)
+	if err != nil || record == nil || record.Response != nil || record.Fingerprint != "POST /a" {
+		t.Fatalf("in-flight reservation returned %+v, %v", record, err)
+	}
+
+	response := StoredResponse{Status: 201, Header: map[string][]string{"Content-Type": {"application/json"}, "Location": {"/tasks/quiz"}}, Body: []byte(`{"error":false}`)}
+	if err := store.CompleteIdempotencyKey(ctx, id, "k1", response); err != nil {
+		t.Fatal(err)
+	}
+	record, err = store.ReserveIdempotencyKey(ctx, id, "k1", "POST /a", since, time.Hour)
+	if err != nil || record == nil || record.Response == nil {
+		t.Fatalf("completed reservation returned %+v, %v", record, err)
+	}
+	if got := *record.Response; got.Status != 201 || fmt.Sprint(got.Header) != fmt.Sprint(response.Header) || string(got.Body) != string(response.Body) {
+		t.Errorf("stored response = %+v", got)
+	}
+
+	// keys are scoped to the user
+	other := mustInsert(t, repo, User{Email: "other-retry@example.com"})
+	if record, err := store.ReserveIdempotencyKey(ctx, other, "k1", "POST /a", since, time.Hour); err != nil || record != nil {
+		t.Errorf("another user's reservation returned %+v, %v", record, err)
+	}
+
+	// an expired key can be reserved again
+	if record, err := store.ReserveIdempotencyKey(ctx, id, "k1", "POST /b", time.Now().Add(time.Minute), time.Hour); err != nil || record != nil {
+		t.Errorf("reservation of an expired key returned %+v, %v", record, err)
+	}
+
+	// the request holding k2 crashed, a retry takes the key over once the lease is over
+	if record, err := store.ReserveIdempotencyKey(ctx, id, "k2", "POST /a", since, -time.Second); err != nil || record != nil {
+		t.Fatalf("reservation of k2 returned %+v, %v", record, err)
+	}
+	if record, err := store.ReserveIdempotencyKey(ctx, id, "k2", "POST /a", since, time.Hour); err != nil || record != nil {
+		t.Errorf("reservation after the lease returned %+v, %v", record, err)
+	}
+	record, err = store.ReserveIdempotencyKey(ctx, id, "k2", "POST /a", since, time.Hour)
+	if err != nil || record == nil || record.Response != nil || time.Until(record.LockedUntil) < 59*time.Minute {
+		t.Errorf("reservation within the new lease returned %+v, %v", record, err)
+	}
+
+	if err := store.ReleaseIdempotencyKey(ctx, id, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := store.ReserveIdempotencyKey(ctx, id, "k1", "POST /a", since, time.Hour); err != nil || record != nil {
+		t.Errorf("reservation of a released key returned %+v, %v", record, err)
+	}
+
+	deleted, err := store.DeleteIdempotencyKeys(ctx, time.Now().Add(time.Minute))
+	if err != nil || deleted != 3 {
+		t.Errorf("DeleteIdempotencyKeys = %d, %v, want 3", deleted, err)
+	}
+}
+
+func testTaskCatalog(t *testing.T, repo Repository) {
+	ctx := context.Background()
+
+	for _, seeded := range DefaultTasks {
+		task, err := repo.GetTask(ctx, seeded.Code)
+		if err != nil || task.Points != seeded.Points || task.Repeatable || task.Verifier != seeded.Verifier {
+			t.Errorf("seeded task %s = %+v, %v", seeded.Code, task, err)
+		}
+	}
+
+	starts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	task := Task{Code: "discord", Title: "Join Discord", Points: 30, StartsAt: &starts, Repeatable: true, MaxCompletions: 3}
+	if _, err := repo.InsertTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertTask(ctx, task); !errors.Is(err, ErrDuplicateTask) {
+		t.Errorf("inserting the code twice returned %v, want ErrDuplicateTask", err)
+	}
+
+	got, err := repo.GetTask(ctx, "discord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Join Discord" || got.Points != 30 || !got.Repeatable || got.MaxCompletions != 3 ||
+		got.StartsAt == nil || !got.StartsAt.Equal(starts) || got.EndsAt != nil {
+		t.Errorf("GetTask = %+v", got)
+	}
+	if got.ActiveAt(starts.Add(-time.Minute)) || !got.ActiveAt(starts) {
+		t.Errorf("task isn't active from %v only", starts)
+	}
+
+	ends := starts.Add(24 * time.Hour)
+	task.Points, task.StartsAt, task.EndsAt = 40, nil, &ends
+	if err := repo.UpdateTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	got, err = repo.GetTask(ctx, "discord")
+	if err != nil || got.Points != 40 || got.StartsAt != nil || got.EndsAt == nil || !got.EndsAt.Equal(ends) {
+		t.Errorf("GetTask after UpdateTask = %+v, %v", got, err)
+	}
+
+	tasks, err := repo.ListTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		codes = append(codes, task.Code)
+	}
+	if fmt.Sprint(codes) != "[discord telegram x]" {
+		t.Errorf("ListTasks codes = %v", codes)
+	}
+
+	if err := repo.DeleteTask(ctx, "discord"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetTask(ctx, "discord"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("GetTask after DeleteTask returned %v", err)
+	}
+	if err := repo.DeleteTask(ctx, "discord"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("deleting a missing task returned %v", err)
+	}
+	if err := repo.UpdateTask(ctx, Task{Code: "missing", Title: "x", Points: 1}); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("updating a missing task returned %v", err)
+	}
+}
+
+func testCompleteTask(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "complete@example.com"})
+
+	completion, err := repo.CompleteTask(ctx, id, "telegram", taskChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completion.TaskCode != "telegram" || completion.Points != 50 || completion.UserID != id {
+		t.Errorf("completion = %+v", completion)
+	}
+	if _, err := repo.CompleteTask(ctx, id, "telegram", taskChange); !errors.Is(err, ErrTaskCompleted) {
+		t.Errorf("completing telegram twice returned %v, want ErrTaskCompleted", err)
+	}
+
+	if _, err := repo.InsertTask(ctx, Task{Code: "daily", Title: "Daily", Points: 5, Repeatable: true, MaxCompletions: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := repo.CompleteTask(ctx, id, "daily", taskChange); err != nil {
+			t.Fatalf("completion %d of a repeatable task: %v", i+1, err)
+		}
+	}
+	if _, err := repo.CompleteTask(ctx, id, "daily", taskChange); !errors.Is(err, ErrTaskCompleted) {
+		t.Errorf("completion over the maximum returned %v, want ErrTaskCompleted", err)
+	}
+
+	ended := time.Now().Add(-time.Hour)
+	if _, err := repo.InsertTask(ctx, Task{Code: "ended", Title: "Ended", Points: 5, EndsAt: &ended}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CompleteTask(ctx, id, "ended", taskChange); !errors.Is(err, ErrTaskInactive) {
+		t.Errorf("completing an ended task returned %v, want ErrTaskInactive", err)
+	}
+	if _, err := repo.CompleteTask(ctx, id, "missing", taskChange); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("completing a missing task returned %v, want ErrTaskNotFound", err)
+	}
+	if _, err := repo.CompleteTask(ctx, id+100, "x", taskChange); !errors.Is(err, ErrNotFound) {
+		t.Errorf("completing for a missing user returned %v, want ErrNotFound", err)
+	}
+
+	if score := mustGetOne(t, repo, id).Score; score != 60 {
+		t.Errorf("score = %d, want 60", score)
+	}
+	history, err := repo.PointHistory(ctx, id, HistoryPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[2].Reference != "telegram" || history[2].Source != SourceTask {
+		t.Errorf("history = %+v", history)
+	}
+
+	tasks, err := repo.UserTasks(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completions := make(map[string]int)
+	for _, task := range tasks {
+		completions[task.Code] = task.Completions
+		if (task.Completions > 0) != (task.LastCompletedAt != nil) {
+			t.Errorf("%s: %d completions, last at %v", task.Code, task.Completions, task.LastCompletedAt)
+		}
+		if available := task.Available(time.Now()); available != (task.Code == "x") {
+			t.Errorf("%s available = %v", task.Code, available)
+		}
+	}
+	if fmt.Sprint(completions) != "map[daily:2 ended:0 telegram:1 x:0]" {
+		t.Errorf("completions = %v", completions)
+	}
+
+	// a completed task stays in the catalog, so it can't be completed once more after
+	// being added again
+	if err := repo.DeleteTask(ctx, "daily"); !errors.Is(err, ErrTaskInUse) {
+		t.Errorf("deleting a completed task returned %v, want ErrTaskInUse", err)
+	}
+	if _, err := repo.GetTask(ctx, "daily"); err != nil {
+		t.Errorf("GetTask after a refused delete returned %v", err)
+	}
+	if err := repo.DeleteTask(ctx, "ended"); err != nil {
+		t.Errorf("deleting a task nobody completed returned %v", err)
+	}
+}
+
+func testConcurrentCompleteTask(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "race@example.com"})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.CompleteTask(ctx, id, "x", taskChange)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	completed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			completed++
+		case !errors.Is(err, ErrTaskCompleted):
+			t.Errorf("CompleteTask: %v", err)
+		}
+	}
+	if completed != 1 {
+		t.Errorf("%d concurrent completions succeeded, want 1", completed)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 75 {
+		t.Errorf("score = %d, want 75", score)
+	}
+}
+
+func testPendingTask(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "pending@example.com"})
+
+	pending, err := repo.SetTaskPending(ctx, id, "x", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := repo.SetTaskPending(ctx, id, "x", "second")
+	if err != nil || again.ID != pending.ID || again.Status != CompletionPending {
+		t.Fatalf("second SetTaskPending = %+v, %v, want the pending completion %d", again, err, pending.ID)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 0 {
+		t.Errorf("score while pending = %d, want 0", score)
+	}
+
+	queue, err := repo.PendingTasks(ctx, 10)
+	if err != nil || len(queue) != 1 || queue[0].TaskCode != "x" || queue[0].Account != "second" || queue[0].UserID != id {
+		t.Fatalf("PendingTasks = %+v, %v", queue, err)
+	}
+	tasks, err := repo.UserTasks(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		if task.Code == "x" && (!task.Pending || task.Completions != 0) {
+			t.Errorf("pending task = %+v", task)
+		}
+	}
+
+	completion, err := repo.CompleteTask(ctx, id, "x", taskChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completion.ID != pending.ID || completion.Status != CompletionCompleted || completion.Account != "second" {
+		t.Errorf("completion of the pending task = %+v", completion)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 75 {
+		t.Errorf("score = %d, want 75", score)
+	}
+	if _, err := repo.SetTaskPending(ctx, id, "x", "third"); !errors.Is(err, ErrTaskCompleted) {
+		t.Errorf("SetTaskPending of a completed task returned %v", err)
+	}
+
+	// the account verified the task for the user, another one can't use it
+	other := mustInsert(t, repo, User{Email: "pending-other@example.com"})
+	if _, err := repo.SetTaskPending(ctx, other, "x", "second"); !errors.Is(err, ErrAccountTaken) {
+		t.Errorf("SetTaskPending with the account of another user returned %v", err)
+	}
+	if _, err := repo.SetTaskPending(ctx, other, "telegram", "second"); err != nil {
+		t.Errorf("SetTaskPending of another task with the account returned %v", err)
+	}
+	if err := repo.CancelPendingTask(ctx, other, "telegram"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.SetTaskPending(ctx, id, "telegram", "tg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SetTaskPending(ctx, other, "telegram", "tg-other"); err != nil {
+		t.Fatal(err)
+	}
+	// a completion failing its verification again doesn't hold back the next one
+	for _, want := range []int{id, other, id} {
+		queue, err := repo.PendingTasks(ctx, 1)
+		if err != nil || len(queue) != 1 || queue[0].UserID != want {
+			t.Fatalf("PendingTasks = %+v, %v, want the completion of user %d", queue, err, want)
+		}
+	}
+	if err := repo.CancelPendingTask(ctx, id, "telegram"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CancelPendingTask(ctx, other, "telegram"); err != nil {
+		t.Fatal(err)
+	}
+	if queue, err := repo.PendingTasks(ctx, 10); err != nil || len(queue) != 0 {
+		t.Errorf("PendingTasks after completing and cancelling = %+v, %v", queue, err)
+	}
+}
+
+func testSetTimezone(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "traveller@example.com"})
+
+	if err := repo.SetTimezone(ctx, id, "Asia/Tokyo", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if tz := mustGetOne(t, repo, id).Timezone; tz != "Asia/Tokyo" {
+		t.Errorf("timezone = %q, want Asia/Tokyo", tz)
+	}
+	if err := repo.SetTimezone(ctx, id, "Asia/Tokyo", time.Hour); err != nil {
+		t.Errorf("setting the same timezone again returned %v", err)
+	}
+
+	var cooldown *CooldownError
+	err := repo.SetTimezone(ctx, id, "Pacific/Kiritimati", time.Hour)
+	if !errors.Is(err, ErrTimezoneChanged) || !errors.As(err, &cooldown) || time.Until(cooldown.AvailableAt) < 59*time.Minute {
+		t.Errorf("second change within the hour returned %v", err)
+	}
+	if tz := mustGetOne(t, repo, id).Timezone; tz != "Asia/Tokyo" {
+		t.Errorf("timezone after a refused change = %q", tz)
+	}
+	if err := repo.SetTimezone(ctx, id, "Pacific/Kiritimati", 0); err != nil {
+		t.Errorf("change without a limit returned %v", err)
+	}
+
+	// Update leaves the timezone alone
+	user := mustGetOne(t, repo, id)
+	user.Timezone = "UTC"
+	if err := repo.Update(ctx, *user); err != nil {
+		t.Fatal(err)
+	}
+	if tz := mustGetOne(t, repo, id).Timezone; tz != "Pacific/Kiritimati" {
+		t.Errorf("timezone after Update = %q", tz)
+	}
+	if err := repo.SetTimezone(ctx, id+1000, "UTC", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetTimezone of a missing user returned %v", err)
+	}
+}
+
+func testTaskLimits(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "limits@example.com", Timezone: "Asia/Tokyo"})
+	if tz := mustGetOne(t, repo, id).Timezone; tz != "Asia/Tokyo" {
+		t.Fatalf("timezone = %q, want Asia/Tokyo", tz)
+	}
+
+	share := Task{Code: "share", Title: "Share a post", Points: 10, Repeatable: true, DailyLimit: 2, WeeklyLimit: 10}
+	call := Task{Code: "call", Title: "Community call", Points: 30, Repeatable: true, CooldownSeconds: 3600}
+	for _, task := range []Task{share, call} {
+		if _, err := repo.InsertTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := repo.GetTask(ctx, "share"); err != nil || got.DailyLimit != 2 || got.WeeklyLimit != 10 {
+		t.Fatalf("GetTask = %+v, %v", got, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := repo.CompleteTask(ctx, id, "share", taskChange); err != nil {
+			t.Fatalf("completion %d of share: %v", i+1, err)
+		}
+	}
+	tomorrow := PeriodsAt(time.Now(), mustGetOne(t, repo, id).Location()).NextDay
+	var cooldown *CooldownError
+	_, err := repo.CompleteTask(ctx, id, "share", taskChange)
+	if !errors.As(err, &cooldown) || !errors.Is(err, ErrTaskCooldown) || !cooldown.AvailableAt.Equal(tomorrow) {
+		t.Errorf("completion over the daily limit returned %v, want a cooldown until %v", err, tomorrow)
+	}
+	if _, err := repo.SetTaskPending(ctx, id, "share", "account"); !errors.Is(err, ErrTaskCooldown) {
+		t.Errorf("SetTaskPending over the daily limit returned %v, want ErrTaskCooldown", err)
+	}
+
+	before := time.Now()
+	if _, err := repo.CompleteTask(ctx, id, "call", taskChange); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.CompleteTask(ctx, id, "call", taskChange)
+	if !errors.As(err, &cooldown) || cooldown.AvailableAt.Before(before.Add(time.Hour-time.Second)) || cooldown.AvailableAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("completion on cooldown returned %v, want a cooldown of an hour", err)
+	}
+
+	tasks, err := repo.UserTasks(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		switch task.Code {
+		case "share":
+			if task.CompletedToday != 2 || task.CompletedThisWeek != 2 || task.AvailableAt == nil || !task.AvailableAt.Equal(tomorrow) {
+				t.Errorf("share = %+v", task)
+			}
+		case "call":
+			if task.Completions != 1 || task.AvailableAt == nil || task.Available(time.Now()) {
+				t.Errorf("call = %+v", task)
+			}
+		case "x":
+			if task.AvailableAt != nil || !task.Available(time.Now()) {
+				t.Errorf("x = %+v", task)
+			}
+		}
+	}
+	if _, err := repo.UserTasks(ctx, id+100); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UserTasks of a missing user returned %v, want ErrNotFound", err)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 50 {
+		t.Errorf("score = %d, want 50", score)
+	}
+}
+
+func testCheckIn(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "checkin@example.com"})
+	rules := CheckinRules{Rewards: []int{10, 20}, FreezeEvery: 1, MaxFreezes: 1}
+	change := PointChange{Source: SourceCheckin, Reason: "test"}
+
+	if streak, err := repo.Streak(ctx, id, rules); err != nil || *streak != (Streak{}) {
+		t.Fatalf("streak before the first check-in = %+v, %v", streak, err)
+	}
+	checkin, err := repo.CheckIn(ctx, id, rules, change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkin.ID == 0 || checkin.UserID != id || checkin.Streak != 1 || checkin.Points != 10 || !checkin.FreezeEarned {
+		t.Errorf("check-in = %+v", checkin)
+	}
+	var cooldown *CooldownError
+	if _, err := repo.CheckIn(ctx, id, rules, change); !errors.As(err, &cooldown) || !errors.Is(err, ErrCheckedIn) {
+		t.Errorf("second check-in of the day returned %v, want ErrCheckedIn", err)
+	}
+	if _, err := repo.CheckIn(ctx, id+100, rules, change); !errors.Is(err, ErrNotFound) {
+		t.Errorf("check-in of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	streak, err := repo.Streak(ctx, id, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streak.Current != 1 || streak.Longest != 1 || streak.Freezes != 1 || streak.LastDay != checkin.Day {
+		t.Errorf("streak = %+v", streak)
+	}
+	if streak, err := repo.GrantStreakFreezes(ctx, id, 2); err != nil || streak.Freezes != 3 || streak.Current != 1 {
+		t.Errorf("GrantStreakFreezes = %+v, %v", streak, err)
+	}
+	if _, err := repo.GrantStreakFreezes(ctx, id+100, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GrantStreakFreezes of a missing user returned %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Streak(ctx, id+100, rules); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Streak of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	if score := mustGetOne(t, repo, id).Score; score != 10 {
+		t.Errorf("score = %d, want 10", score)
+	}
+	history, err := repo.PointHistory(ctx, id, HistoryPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Source != SourceCheckin || history[0].Reference != checkin.Day || history[0].Delta != 10 {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func testQuests(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	if _, err := repo.InsertTask(ctx, Task{Code: "quiz", Title: "Quiz", Points: 5, Repeatable: true}); err != nil {
+		t.Fatal(err)
+	}
+	social := Quest{
+		Code:    "social",
+		Title:   "Join us",
+		Bonus:   100,
+		Ordered: true,
+		Steps: []QuestStep{
+			{Event: QuestEventTask, Target: "telegram", Count: 1},
+			{Event: QuestEventTask, Target: "x", Count: 1},
+			{Event: QuestEventTask, Target: "quiz", Count: 1},
+		},
+	}
+	friends := Quest{
+		Code:     "friends",
+		Title:    "Invite two friends",
+		Bonus:    200,
+		Requires: []string{"social", "social"},
+		Steps:    []QuestStep{{Event: QuestEventReferral, Count: 2}},
+	}
+	// only the points of the tasks count for the first step and nobody checks in
+	treasure := Quest{
+		Code:  "treasure",
+		Title: "Collect points",
+		Bonus: 300,
+		Steps: []QuestStep{{Event: QuestEventPoints, Target: SourceTask, Count: 100}, {Event: QuestEventCheckin, Count: 1}},
+	}
+	for _, quest := range []Quest{social, friends, treasure} {
+		if id, err := repo.InsertQuest(ctx, quest); err != nil || id == 0 {
+			t.Fatalf("InsertQuest(%s) = %d, %v", quest.Code, id, err)
+		}
+	}
+	if _, err := repo.InsertQuest(ctx, social); !errors.Is(err, ErrDuplicateQuest) {
+		t.Errorf("duplicate quest returned %v, want ErrDuplicateQuest", err)
+	}
+	unknown := Quest{Code: "later", Title: "Later", Bonus: 1, Requires: []string{"nope"}, Steps: friends.Steps}
+	if _, err := repo.InsertQuest(ctx, unknown); !errors.Is(err, ErrUnknownPrerequisite) {
+		t.Errorf("quest with an unknown prerequisite returned %v, want ErrUnknownPrerequisite", err)
+	}
+	if _, err := repo.GetQuest(ctx, "later"); !errors.Is(err, ErrQuestNotFound) {
+		t.Errorf("GetQuest of a refused quest returned %v, want ErrQuestNotFound", err)
+	}
+	stored, err := repo.GetQuest(ctx, "friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Requires) != 1 || stored.Requires[0] != "social" || len(stored.Steps) != 1 || stored.Steps[0] != friends.Steps[0] {
+		t.Errorf("stored quest = %+v", stored)
+	}
+	if quests, err := repo.ListQuests(ctx); err != nil || len(quests) != 3 || quests[0].Code != "friends" || len(quests[1].Steps) != 3 {
+		t.Errorf("ListQuests = %+v, %v", quests, err)
+	}
+
+	id := mustInsert(t, repo, User{Email: "quester@example.com", Referrer: "QUEST"})
+	invite := func(email string) {
+		t.Helper()
+		friend := mustInsert(t, repo, User{Email: email})
+		if err := repo.RedeemReferrer(ctx, friend, "QUEST"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	complete := func(code string) {
+		t.Helper()
+		if _, err := repo.CompleteTask(ctx, id, code, taskChange); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the steps are done in order and the referrals don't count before social unlocks friends
+	complete("quiz")
+	invite("early@example.com")
+	complete("telegram")
+	complete("x")
+	userQuests, err := repo.UserQuests(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userQuests) != 3 || userQuests[0].Unlocked || userQuests[0].Progress[0] != 0 {
+		t.Fatalf("quests before completing social = %+v", userQuests)
+	}
+	if q := userQuests[1]; !q.Unlocked || q.CompletedAt != nil || fmt.Sprint(q.Progress) != "[1 1 0]" {
+		t.Errorf("social before its last step = %+v", q)
+	}
+
+	complete("quiz")
+	invite("first@example.com")
+	invite("second@example.com")
+	userQuests, err = repo.UserQuests(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range userQuests[:2] {
+		if !q.Unlocked || q.CompletedAt == nil {
+			t.Errorf("quest %s = %+v, want completed", q.Code, q)
+		}
+	}
+	if fmt.Sprint(userQuests[0].Progress, userQuests[1].Progress) != "[2] [1 1 1]" {
+		t.Errorf("progress = %v %v", userQuests[0].Progress, userQuests[1].Progress)
+	}
+	if q := userQuests[2]; q.CompletedAt != nil || fmt.Sprint(q.Progress) != "[100 0]" {
+		t.Errorf("treasure = %+v", q)
+	}
+
+	want := 5 + 50 + 75 + 5 + 100 + 3*ReferrerBonus + 200
+	if score := mustGetOne(t, repo, id).Score; score != want {
+		t.Errorf("score = %d, want %d", score, want)
+	}
+	history, err := repo.PointHistory(ctx, id, HistoryPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bonuses []string
+	for _, entry := range history {
+		if entry.Source == SourceQuest {
+			bonuses = append(bonuses, fmt.Sprintf("%s:%d", entry.Reference, entry.Delta))
+		}
+	}
+	if fmt.Sprint(bonuses) != "[friends:200 social:100]" {
+		t.Errorf("quest bonuses in the ledger = %v", bonuses)
+	}
+
+	if _, err := repo.UserQuests(ctx, id+100); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UserQuests of a missing user returned %v, want ErrNotFound", err)
+	}
+	// social is required by friends and both were completed, only treasure can go
+	for _, code := range []string{"social", "friends"} {
+		if err := repo.DeleteQuest(ctx, code); !errors.Is(err, ErrQuestInUse) {
+			t.Errorf("deleting %s returned %v, want ErrQuestInUse", code, err)
+		}
+	}
+	if quest, err := repo.GetQuest(ctx, "friends"); err != nil || len(quest.Requires) != 1 {
+		t.Errorf("friends after the refused delete = %+v, %v", quest, err)
+	}
+	if err := repo.DeleteQuest(ctx, "treasure"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteQuest(ctx, "treasure"); !errors.Is(err, ErrQuestNotFound) {
+		t.Errorf("deleting a missing quest returned %v, want ErrQuestNotFound", err)
+	}
+	if userQuests, err := repo.UserQuests(ctx, id); err != nil || len(userQuests) != 2 {
+		t.Errorf("quests after deleting treasure = %+v, %v", userQuests, err)
+	}
+}
+
+func testSubmissions(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	id := mustInsert(t, repo, User{Email: "submitter@example.com"})
+	admin := mustInsert(t, repo, User{Email: "moderator@example.com", Role: RoleAdmin})
+	if _, err := repo.InsertTask(ctx, Task{Code: "review", Title: "Write a review", Points: 40, Verifier: "manual"}); err != nil {
+		t.Fatal(err)
+	}
+
+	submission, err := repo.SubmitTask(ctx, Submission{UserID: id, TaskCode: "review", Proof: "https://example.com/review", ImageKey: "submissions/1.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if submission.ID == 0 || submission.Status != SubmissionPending || submission.ImageKey != "submissions/1.png" {
+		t.Errorf("submission = %+v", submission)
+	}
+	if _, err := repo.SubmitTask(ctx, Submission{UserID: id, TaskCode: "review", Proof: "again"}); !errors.Is(err, ErrSubmissionPending) {
+		t.Errorf("second pending submission returned %v, want ErrSubmissionPending", err)
+	}
+	if err := repo.DeleteTask(ctx, "review"); !errors.Is(err, ErrTaskInUse) {
+		t.Errorf("deleting a submitted task returned %v, want ErrTaskInUse", err)
+	}
+	if _, err := repo.SubmitTask(ctx, Submission{UserID: id, TaskCode: "missing", Proof: "p"}); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("submission of a missing task returned %v, want ErrTaskNotFound", err)
+	}
+	if _, err := repo.SubmitTask(ctx, Submission{UserID: id + 100, TaskCode: "review", Proof: "p"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("submission of a missing user returned %v, want ErrNotFound", err)
+	}
+
+	approved, err := repo.ApproveSubmission(ctx, submission.ID, PointChange{Source: SourceTask, Reason: "approved", ActorID: admin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != SubmissionApproved || approved.Points != 40 || approved.ReviewerID != admin || approved.ReviewedAt == nil {
+		t.Errorf("approved submission = %+v", approved)
+	}
+	if _, err := repo.ApproveSubmission(ctx, submission.ID, taskChange); !errors.Is(err, ErrSubmissionReviewed) {
+		t.Errorf("second approval returned %v, want ErrSubmissionReviewed", err)
+	}
+	if _, err := repo.RejectSubmission(ctx, submission.ID+100, admin, "no"); !errors.Is(err, ErrSubmissionNotFound) {
+		t.Errorf("rejection of a missing submission returned %v, want ErrSubmissionNotFound", err)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 40 {
+		t.Errorf("score = %d, want 40", score)
+	}
+	history, err := repo.PointHistory(ctx, id, HistoryPage{})
+	if err != nil || len(history) != 1 || history[0].Reference != "review" || history[0].ActorID != admin {
+		t.Errorf("history = %+v, %v", history, err)
+	}
+	if _, err := repo.SubmitTask(ctx, Submission{UserID: id, TaskCode: "review", Proof: "again"}); !errors.Is(err, ErrTaskCompleted) {
+		t.Errorf("submission of a completed task returned %v, want ErrTaskCompleted", err)
+	}
+
+	// a rejected submission awards nothing and can be sent again
+	if _, err := repo.InsertTask(ctx, Task{Code: "video", Title: "Make a video", Points: 100, Verifier: "manual"}); err != nil {
+		t.Fatal(err)
+	}
+	video, err := repo.SubmitTask(ctx, Submission{UserID: id, TaskCode: "video", Proof: "a video"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := repo.RejectSubmission(ctx, video.ID, admin, "the link is broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Status != SubmissionRejected || rejected.Reason != "the link is broken" || rejected.Points != 0 {
+		t.Errorf("rejected submission = %+v", rejected)
+	}
+	if _, err := repo.ApproveSubmission(ctx, video.ID, taskChange); !errors.Is(err, ErrSubmissionReviewed) {
+		t.Errorf("approval of a rejected submission returned %v, want ErrSubmissionReviewed", err)
+	}
+	resent, err := repo.SubmitTask(ctx, Submission{UserID: id, TaskCode: "video", Proof: "a working link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := mustGetOne(t, repo, id).Score; score != 40 {
+		t.Errorf("score after the rejection = %d, want 40", score)
+	}
+
+	got, err := repo.GetSubmission(ctx, video.ID)
+	if err != nil || got.Status != SubmissionRejected || got.TaskCode != "video" || got.Reason != "the link is broken" {
+		t.Errorf("GetSubmission = %+v, %v", got, err)
+	}
+	if _, err := repo.GetSubmission(ctx, resent.ID+100); !errors.Is(err, ErrSubmissionNotFound) {
+		t.Errorf("GetSubmission of a missing id returned %v", err)
+	}
+
+	queue, err := repo.ListSubmissions(ctx, SubmissionFilter{Status: SubmissionPending}, HistoryPage{})
+	if err != nil || len(queue) != 1 || queue[0].ID != resent.ID {
+		t.Errorf("pending submissions = %+v, %v", queue, err)
+	}
+	all, err := repo.ListSubmissions(ctx, SubmissionFilter{UserID: id}, HistoryPage{Limit: 2})
+	if err != nil || len(all) != 2 || all[0].ID != resent.ID || all[1].ID != video.ID {
+		t.Fatalf("first page = %+v, %v", all, err)
+	}
+	next, err := repo.ListSubmissions(ctx, SubmissionFilter{UserID: id}, HistoryPage{Before: all[1].ID, Limit: 2})
+	if err != nil || len(next) != 1 || next[0].ID != submission.ID {
+		t.Errorf("second page = %+v, %v", next, err)
+	}
+	if others, err := repo.ListSubmissions(ctx, SubmissionFilter{UserID: admin}, HistoryPage{}); err != nil || len(others) != 0 {
+		t.Errorf("submissions of another user = %+v, %v", others, err)
+	}
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteRepository is the Repository on a SQLite database, it runs the same queries
+// as PostgresRepository. SQLite allows a single writer, so the pool is limited to one
+// connection, which also makes the score increments atomic.
+type SQLiteRepository struct {
+	sqlRepository
+}
+
+// NewSQLiteRepository returns a repository on pool, a pool opened by Open on a sqlite:// DSN
+func NewSQLiteRepository(pool *sql.DB) *SQLiteRepository {
+	pool.SetMaxOpenConns(1)
+
+	return &SQLiteRepository{
+		sqlRepository: sqlRepository{
+			Conn:              pool,
+			Timeout:           dbTimeout,
+			BcryptCost:        bcryptCost,
+			isUniqueViolation: isSQLiteUniqueViolation,
+		},
+	}
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// NewSQLiteRepositoryWithOptions returns a repository on pool after tuning the pool with opts,
+// MaxOpenConns is ignored as SQLite is always used through a single connection
+func NewSQLiteRepositoryWithOptions(pool *sql.DB, opts PoolOptions) *SQLiteRepository {
+	opts.MaxOpenConns = 0
+	opts.Apply(pool)
+
+	repo := NewSQLiteRepository(pool)
+	if opts.QueryTimeout > 0 {
+		repo.Timeout = opts.QueryTimeout
+	}
+	if opts.BcryptCost > 0 {
+		repo.BcryptCost = opts.BcryptCost
+	}
+
+	return repo
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newSQLiteRepository returns a repository on a fresh, migrated database file
+func newSQLiteRepository(t *testing.T) *SQLiteRepository {
+	t.Helper()
+
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "users.db")
+
+	migrator, err := NewMigrator(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	migrator.Close()
+
+	pool, err := Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	// the default cost makes every password hash take most of the query timeout under -race
+	return NewSQLiteRepositoryWithOptions(pool, PoolOptions{BcryptCost: bcrypt.MinCost})
+}
+
+// TestSQLiteRepository runs the contract against a fresh, migrated database file for every test
+func TestSQLiteRepository(t *testing.T) {
+	testRepositoryContract(t, func(t *testing.T) Repository {
+		return newSQLiteRepository(t)
+	})
+}
+
+func TestSQLiteMigrationsDown(t *testing.T) {
+	migrator, err := NewMigrator("sqlite://" + filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+
+	if err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := LatestMigration(BackendSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, dirty, _ := migrator.Version(); version != latest || dirty {
+		t.Errorf("version after up = %d (dirty %v), want %d", version, dirty, latest)
+	}
+
+	for version := latest; version > 0; {
+		if err := migrator.Down(1); err != nil {
+			t.Fatal(err)
+		}
+		if version, _, err = migrator.Version(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
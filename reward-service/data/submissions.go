@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// statuses of a task submission
+const (
+	SubmissionPending  = "pending"
+	SubmissionApproved = "approved"
+	SubmissionRejected = "rejected"
+)
+
+// SubmissionQueue is the storage of the task completions a moderator reviews before
+// their points are awarded
+type SubmissionQueue interface {
+	// SubmitTask queues a submission of a task for review
+	SubmitTask(ctx context.Context, submission Submission) (*Submission, error)
+	GetSubmission(ctx context.Context, id int64) (*Submission, error)
+	// ListSubmissions returns one page of the submissions matching the filter, newest first
+	ListSubmissions(ctx context.Context, filter SubmissionFilter, page HistoryPage) ([]Submission, error)
+	// ApproveSubmission completes the task of a pending submission and awards its points
+	ApproveSubmission(ctx context.Context, id int64, change PointChange) (*Submission, error)
+	// RejectSubmission closes a pending submission without awarding points
+	RejectSubmission(ctx context.Context, id int64, reviewerID int, reason string) (*Submission, error)
+}
+
+// Submission is a completion of a task sent with a proof, a text or a URL, and
+// optionally the key of an uploaded image in the blob store. Points are the points
+// awarded once it is approved, Reason explains a rejection.
+type Submission struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"user_id"`
+	TaskCode   string     `json:"task_code"`
+	Proof      string     `json:"proof"`
+	ImageKey   string     `json:"-"`
+	Status     string     `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	Points     int        `json:"points,omitempty"`
+	ReviewerID int        `json:"reviewer_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// SubmissionFilter selects the submissions of a status and of a user, the zero values
+// select every status and every user
+type SubmissionFilter struct {
+	Status string
+	UserID int
+}
+
+// matches reports whether the submission is selected by the filter
+func (f SubmissionFilter) matches(s *Submission) bool {
+	return (f.Status == "" || s.Status == f.Status) && (f.UserID == 0 || s.UserID == f.UserID)
+}
+
+const submissionColumns = `s.id, s.user_id, t.code, s.proof, s.image_key, s.status, s.reason, s.points,
+	s.reviewer_id, s.created_at, s.reviewed_at from task_submissions s join tasks t on t.id = s.task_id`
+
+// scanSubmission reads a row selected with submissionColumns
+func scanSubmission(row interface{ Scan(dest ...any) error }) (*Submission, error) {
+	var s Submission
+	var reviewer sql.NullInt64
+	var reviewedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.TaskCode, &s.Proof, &s.ImageKey, &s.Status, &s.Reason, &s.Points,
+		&reviewer, &s.CreatedAt, &reviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.ReviewerID = int(reviewer.Int64)
+	if reviewedAt.Valid {
+		s.ReviewedAt = &reviewedAt.Time
+	}
+
+	return &s, nil
+}
+
+// SubmitTask queues the submission for review. The task must be one the user can
+// complete now, and a user has at most one pending submission of a task.
+func (u *sqlRepository) SubmitTask(ctx context.Context, submission Submission) (queued *Submission, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "SubmitTask")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	state, err := lockCompletion(ctx, tx, submission.UserID, submission.TaskCode, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := state.task.CanComplete(state.history, now); err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into task_submissions (user_id, task_id, proof, image_key, status, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+	err = tx.QueryRowContext(ctx, stmt, submission.UserID, state.task.ID, submission.Proof, submission.ImageKey,
+		SubmissionPending, now).Scan(&submission.ID)
+	if u.isUniqueViolation(err) {
+		return nil, ErrSubmissionPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	submission.Status = SubmissionPending
+	submission.Reason, submission.Points, submission.ReviewerID, submission.ReviewedAt = "", 0, 0, nil
+	submission.CreatedAt = now
+
+	return &submission, nil
+}
+
+// GetSubmission returns the submission with the id
+func (u *sqlRepository) GetSubmission(ctx context.Context, id int64) (submission *Submission, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "GetSubmission")
+	defer func() { done(err) }()
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		submission, err = scanSubmission(db.QueryRowContext(ctx, `select `+submissionColumns+` where s.id = $1`, id))
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSubmissionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return submission, nil
+}
+
+// ListSubmissions returns one page of the submissions matching the filter, newest first
+func (u *sqlRepository) ListSubmissions(ctx context.Context, filter SubmissionFilter, page HistoryPage) (submissions []Submission, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "ListSubmissions")
+	defer func() { done(err) }()
+
+	query := `select ` + submissionColumns + `
+		where ($1 = '' or s.status = $1) and ($2 = 0 or s.user_id = $2) and ($3 = 0 or s.id < $3)
+		order by s.id desc limit $4`
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		submissions = []Submission{}
+		rows, err := db.QueryContext(ctx, query, filter.Status, filter.UserID, page.Before, page.limit())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			submission, err := scanSubmission(rows)
+			if err != nil {
+				return fmt.Errorf("scanning task submission: %w", err)
+			}
+			submissions = append(submissions, *submission)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return submissions, nil
+}
+
+// ApproveSubmission completes the task of the pending submission for its user and awards
+// the points in one transaction, like any other completion of the task. The reviewer is
+// the actor of the change.
+func (u *sqlRepository) ApproveSubmission(ctx context.Context, id int64, change PointChange) (submission *Submission, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "ApproveSubmission")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pending, err := lockSubmission(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	completion, err := u.completeTask(ctx, tx, pending.UserID, pending.TaskCode, change)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `update task_submissions set status = $1, points = $2, reviewer_id = $3, reviewed_at = $4 where id = $5`
+	_, err = tx.ExecContext(ctx, stmt, SubmissionApproved, completion.Points, nullID(change.ActorID), time.Now().UTC(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return reviewed(ctx, tx, id)
+}
+
+// RejectSubmission closes the pending submission with the reason, no points are awarded
+func (u *sqlRepository) RejectSubmission(ctx context.Context, id int64, reviewerID int, reason string) (submission *Submission, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "RejectSubmission")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockSubmission(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	stmt := `update task_submissions set status = $1, reason = $2, reviewer_id = $3, reviewed_at = $4 where id = $5`
+	_, err = tx.ExecContext(ctx, stmt, SubmissionRejected, reason, nullID(reviewerID), time.Now().UTC(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return reviewed(ctx, tx, id)
+}
+
+// lockSubmission locks the submission row, so two moderators reviewing it at once are
+// serialized, and returns it when it is still pending. It is locked before the user row
+// of its completion.
+func lockSubmission(ctx context.Context, tx *sql.Tx, id int64) (*Submission, error) {
+	var submission Submission
+	var taskID int
+	err := tx.QueryRowContext(ctx, `update task_submissions set status = status where id = $1 returning user_id, task_id, status`, id).
+		Scan(&submission.UserID, &taskID, &submission.Status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSubmissionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if submission.Status != SubmissionPending {
+		return nil, ErrSubmissionReviewed
+	}
+
+	err = tx.QueryRowContext(ctx, `select code from tasks where id = $1`, taskID).Scan(&submission.TaskCode)
+	if err != nil {
+		return nil, err
+	}
+	submission.ID = id
+
+	return &submission, nil
+}
+
+// reviewed reads the submission back and commits the review
+func reviewed(ctx context.Context, tx *sql.Tx, id int64) (*Submission, error) {
+	submission, err := scanSubmission(tx.QueryRowContext(ctx, `select `+submissionColumns+` where s.id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return submission, nil
+}
+
+// nullID stores the id of an optional user, zero is no user
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
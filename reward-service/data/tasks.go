@@ -0,0 +1,358 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TaskCatalog is the storage of the tasks users complete for points, a task is
+// addressed by its code
+type TaskCatalog interface {
+	ListTasks(ctx context.Context) ([]Task, error)
+	GetTask(ctx context.Context, code string) (*Task, error)
+	InsertTask(ctx context.Context, task Task) (int, error)
+	UpdateTask(ctx context.Context, task Task) error
+	DeleteTask(ctx context.Context, code string) error
+	// CompleteTask records that the user completed the task and awards its points
+	CompleteTask(ctx context.Context, userID int, code string, change PointChange) (*TaskCompletion, error)
+	// SetTaskPending records a completion waiting for its verification
+	SetTaskPending(ctx context.Context, userID int, code, account string) (*TaskCompletion, error)
+	// PendingTasks returns the completions waiting for their verification, the ones attempted
+	// least recently first
+	PendingTasks(ctx context.Context, limit int) ([]TaskCompletion, error)
+	// CancelPendingTask drops the pending completion of a task which failed its verification
+	CancelPendingTask(ctx context.Context, userID int, code string) error
+	// UserTasks returns the catalog with the completions of the user
+	UserTasks(ctx context.Context, userID int) ([]UserTask, error)
+}
+
+// Task is an entry of the task catalog. The task can only be completed between StartsAt
+// and EndsAt when they are set. MaxCompletions bounds how often a user may complete a
+// repeatable task, zero means no bound. A repeatable task can further wait CooldownSeconds
+// after each completion and be completed at most DailyLimit times a day and WeeklyLimit
+// times a week, counted in the timezone of the user, zero means no limit. Verifier names
+// the check a completion has to pass before the points are awarded, empty when the
+// completion is trusted.
+type Task struct {
+	ID              int        `json:"id"`
+	Code            string     `json:"code"`
+	Title           string     `json:"title"`
+	Description     string     `json:"description,omitempty"`
+	Points          int        `json:"points"`
+	StartsAt        *time.Time `json:"starts_at,omitempty"`
+	EndsAt          *time.Time `json:"ends_at,omitempty"`
+	Repeatable      bool       `json:"repeatable"`
+	MaxCompletions  int        `json:"max_completions"`
+	CooldownSeconds int        `json:"cooldown_seconds"`
+	DailyLimit      int        `json:"daily_limit"`
+	WeeklyLimit     int        `json:"weekly_limit"`
+	Verifier        string     `json:"verifier,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// DefaultTasks are the tasks the tasks migration seeds, they had their own routes before
+// the catalog existed
+var DefaultTasks = []Task{
+	{Code: "telegram", Title: "Subscribe to the Telegram channel", Points: 50, Verifier: "telegram"},
+	{Code: "x", Title: "Follow the X account", Points: 75, Verifier: "x"},
+}
+
+// ActiveAt reports whether the task can be completed at the time
+func (t *Task) ActiveAt(now time.Time) bool {
+	if t.StartsAt != nil && now.Before(*t.StartsAt) {
+		return false
+	}
+	if t.EndsAt != nil && !now.Before(*t.EndsAt) {
+		return false
+	}
+
+	return true
+}
+
+// CanComplete tells whether a user with the completion history may complete the task
+// again at the time. A task which isn't repeatable is completed once. A repeatable task on
+// cooldown or over a limit returns a *CooldownError telling when it is available again.
+func (t *Task) CanComplete(history CompletionHistory, now time.Time) error {
+	if !t.ActiveAt(now) {
+		return ErrTaskInactive
+	}
+	if !t.Repeatable && history.Total > 0 {
+		return ErrTaskCompleted
+	}
+	if t.Repeatable && t.MaxCompletions > 0 && history.Total >= t.MaxCompletions {
+		return ErrTaskCompleted
+	}
+
+	var next time.Time
+	if t.CooldownSeconds > 0 && !history.Last.IsZero() {
+		if end := history.Last.Add(time.Duration(t.CooldownSeconds) * time.Second); now.Before(end) {
+			next = end
+		}
+	}
+	if t.DailyLimit > 0 && history.Today >= t.DailyLimit && history.Periods.NextDay.After(next) {
+		next = history.Periods.NextDay
+	}
+	if t.WeeklyLimit > 0 && history.ThisWeek >= t.WeeklyLimit && history.Periods.NextWeek.After(next) {
+		next = history.Periods.NextWeek
+	}
+	if next.IsZero() {
+		return nil
+	}
+	if t.EndsAt != nil && !next.Before(*t.EndsAt) {
+		// the task ends before it is available again
+		return ErrTaskCompleted
+	}
+
+	return &CooldownError{Err: ErrTaskCooldown, AvailableAt: next}
+}
+
+// CooldownError is Err, ErrTaskCooldown, ErrTimezoneChanged or ErrCheckedIn, with the
+// time the action is available again
+type CooldownError struct {
+	Err         error
+	AvailableAt time.Time
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("%s until %s", e.Err, e.AvailableAt.UTC().Format(time.RFC3339))
+}
+
+func (e *CooldownError) Unwrap() error {
+	return e.Err
+}
+
+// Periods are the calendar day and the week, starting on Monday, of a user at a time, in
+// the timezone of the user
+type Periods struct {
+	DayStart  time.Time
+	NextDay   time.Time
+	WeekStart time.Time
+	NextWeek  time.Time
+}
+
+// PeriodsAt returns the day and the week in the location at the time
+func PeriodsAt(now time.Time, loc *time.Location) Periods {
+	year, month, day := now.In(loc).Date()
+	// days since Monday
+	weekday := (int(now.In(loc).Weekday()) + 6) % 7
+
+	return Periods{
+		DayStart:  time.Date(year, month, day, 0, 0, 0, 0, loc),
+		NextDay:   time.Date(year, month, day+1, 0, 0, 0, 0, loc),
+		WeekStart: time.Date(year, month, day-weekday, 0, 0, 0, 0, loc),
+		NextWeek:  time.Date(year, month, day-weekday+7, 0, 0, 0, 0, loc),
+	}
+}
+
+// CompletionHistory is what the limits of a task know about the completions of a user:
+// how many there are, how many of them fall into the current periods and when the last was
+type CompletionHistory struct {
+	Total    int
+	Today    int
+	ThisWeek int
+	Last     time.Time
+	Periods  Periods
+}
+
+// add counts a completion at the time
+func (h *CompletionHistory) add(completedAt time.Time) {
+	h.Total++
+	if !completedAt.Before(h.Periods.DayStart) {
+		h.Today++
+	}
+	if !completedAt.Before(h.Periods.WeekStart) {
+		h.ThisWeek++
+	}
+	if completedAt.After(h.Last) {
+		h.Last = completedAt
+	}
+}
+
+const taskColumns = `id, code, title, description, points, starts_at, ends_at, repeatable, max_completions,
+	cooldown_seconds, daily_limit, weekly_limit, verifier, created_at, updated_at`
+
+// scanTask reads a row selected with taskColumns
+func scanTask(row interface{ Scan(dest ...any) error }) (*Task, error) {
+	var t Task
+	var startsAt, endsAt sql.NullTime
+	err := row.Scan(&t.ID, &t.Code, &t.Title, &t.Description, &t.Points, &startsAt, &endsAt,
+		&t.Repeatable, &t.MaxCompletions, &t.CooldownSeconds, &t.DailyLimit, &t.WeeklyLimit, &t.Verifier,
+		&t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if startsAt.Valid {
+		t.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		t.EndsAt = &endsAt.Time
+	}
+
+	return &t, nil
+}
+
+// nullTime converts an optional time for storing it, in UTC like every other time
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// ListTasks returns the whole catalog, sorted by code
+func (u *sqlRepository) ListTasks(ctx context.Context) (tasks []Task, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "ListTasks")
+	defer func() { done(err) }()
+
+	query := `select ` + taskColumns + ` from tasks order by code`
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		tasks = []Task{}
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			task, err := scanTask(rows)
+			if err != nil {
+				return fmt.Errorf("scanning task: %w", err)
+			}
+			tasks = append(tasks, *task)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// GetTask returns the task with the code
+func (u *sqlRepository) GetTask(ctx context.Context, code string) (task *Task, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "GetTask")
+	defer func() { done(err) }()
+
+	query := `select ` + taskColumns + ` from tasks where code = $1`
+
+	err = u.read(ctx, func(db *sql.DB) error {
+		task, err = scanTask(db.QueryRowContext(ctx, query, code))
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// InsertTask adds a task to the catalog and returns its id
+func (u *sqlRepository) InsertTask(ctx context.Context, task Task) (id int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "InsertTask")
+	defer func() { done(err) }()
+
+	stmt := `insert into tasks (code, title, description, points, starts_at, ends_at, repeatable, max_completions,
+		cooldown_seconds, daily_limit, weekly_limit, verifier, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) returning id`
+
+	now := time.Now().UTC()
+	err = u.Conn.QueryRowContext(ctx, stmt, task.Code, task.Title, task.Description, task.Points,
+		nullTime(task.StartsAt), nullTime(task.EndsAt), task.Repeatable, task.MaxCompletions,
+		task.CooldownSeconds, task.DailyLimit, task.WeeklyLimit, task.Verifier, now, now).Scan(&id)
+	if u.isUniqueViolation(err) {
+		return 0, ErrDuplicateTask
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateTask replaces every field of the task with the code, except the code itself
+func (u *sqlRepository) UpdateTask(ctx context.Context, task Task) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "UpdateTask")
+	defer func() { done(err) }()
+
+	stmt := `update tasks set
+		title = $1,
+		description = $2,
+		points = $3,
+		starts_at = $4,
+		ends_at = $5,
+		repeatable = $6,
+		max_completions = $7,
+		cooldown_seconds = $8,
+		daily_limit = $9,
+		weekly_limit = $10,
+		verifier = $11,
+		updated_at = $12
+		where code = $13
+	`
+
+	err = affected(u.Conn.ExecContext(ctx, stmt, task.Title, task.Description, task.Points,
+		nullTime(task.StartsAt), nullTime(task.EndsAt), task.Repeatable, task.MaxCompletions,
+		task.CooldownSeconds, task.DailyLimit, task.WeeklyLimit, task.Verifier, time.Now().UTC(), task.Code))
+	if errors.Is(err, ErrNotFound) {
+		return ErrTaskNotFound
+	}
+
+	return err
+}
+
+// DeleteTask removes the task with the code from the catalog, as long as no user completed
+// or submitted it. Their history references the task, one which was ever used is
+// deactivated with UpdateTask instead.
+func (u *sqlRepository) DeleteTask(ctx context.Context, code string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
+	ctx, done := u.observe(ctx, "DeleteTask")
+	defer func() { done(err) }()
+
+	tx, err := u.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var used bool
+	query := `select id, exists (select 1 from task_completions where task_id = tasks.id)
+		or exists (select 1 from task_submissions where task_id = tasks.id)
+		from tasks where code = $1`
+	err = tx.QueryRowContext(ctx, query, code).Scan(&id, &used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrTaskInUse
+	}
+
+	if _, err := tx.ExecContext(ctx, `delete from tasks where id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
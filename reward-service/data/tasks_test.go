@@ -0,0 +1,67 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPeriodsAt(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+
+	// 23:30 on Sunday in Moscow, the last day of its week, is already Monday in UTC+9
+	now := time.Date(2025, 3, 16, 20, 30, 0, 0, time.UTC)
+	periods := PeriodsAt(now, moscow)
+	if want := time.Date(2025, 3, 16, 0, 0, 0, 0, moscow); !periods.DayStart.Equal(want) {
+		t.Errorf("DayStart = %v, want %v", periods.DayStart, want)
+	}
+	if want := time.Date(2025, 3, 17, 0, 0, 0, 0, moscow); !periods.NextDay.Equal(want) || !periods.NextWeek.Equal(want) {
+		t.Errorf("NextDay = %v, NextWeek = %v, want %v", periods.NextDay, periods.NextWeek, want)
+	}
+	if want := time.Date(2025, 3, 10, 0, 0, 0, 0, moscow); !periods.WeekStart.Equal(want) {
+		t.Errorf("WeekStart = %v, want %v", periods.WeekStart, want)
+	}
+}
+
+func TestCanCompleteLimits(t *testing.T) {
+	now := time.Date(2025, 3, 12, 12, 0, 0, 0, time.UTC)
+	history := CompletionHistory{Periods: PeriodsAt(now, time.UTC)}
+	history.add(now.Add(-8 * 24 * time.Hour))
+	history.add(now.Add(-time.Hour))
+	history.add(now.Add(-10 * time.Minute))
+	if history.Total != 3 || history.Today != 2 || history.ThisWeek != 2 {
+		t.Fatalf("history = %+v", history)
+	}
+
+	tests := []struct {
+		name string
+		task Task
+		want time.Time
+	}{
+		{"unlimited", Task{Repeatable: true}, time.Time{}},
+		{"cooldown", Task{Repeatable: true, CooldownSeconds: 3600}, now.Add(50 * time.Minute)},
+		{"elapsed cooldown", Task{Repeatable: true, CooldownSeconds: 600}, time.Time{}},
+		{"daily limit", Task{Repeatable: true, DailyLimit: 2}, history.Periods.NextDay},
+		{"weekly limit", Task{Repeatable: true, DailyLimit: 2, WeeklyLimit: 2}, history.Periods.NextWeek},
+		{"daily limit below", Task{Repeatable: true, DailyLimit: 3}, time.Time{}},
+	}
+	for _, tt := range tests {
+		err := tt.task.CanComplete(history, now)
+		var cooldown *CooldownError
+		switch {
+		case tt.want.IsZero() && err != nil:
+			t.Errorf("%s: CanComplete = %v, want nil", tt.name, err)
+		case !tt.want.IsZero() && (!errors.As(err, &cooldown) || !cooldown.AvailableAt.Equal(tt.want)):
+			t.Errorf("%s: CanComplete = %v, want a cooldown until %v", tt.name, err, tt.want)
+		}
+	}
+
+	ends := now.Add(time.Hour)
+	task := Task{Repeatable: true, DailyLimit: 2, EndsAt: &ends}
+	if err := task.CanComplete(history, now); !errors.Is(err, ErrTaskCompleted) {
+		t.Errorf("limit past the end of the task = %v, want ErrTaskCompleted", err)
+	}
+}